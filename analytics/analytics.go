@@ -0,0 +1,126 @@
+package analytics
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/michaelhenkel/contrail-init/k8s"
+	"github.com/michaelhenkel/contrail-init/registry"
+)
+
+const (
+	analyticsDBService = "contrail-analytics-db"
+	analyticsDBPort    = 9042
+	analyticsAPIPort   = 8081
+)
+
+type Analytics struct {
+	K8S *k8s.K8S
+
+	analyticsDB []k8s.Endpoint
+	collector   k8s.Endpoint
+}
+
+func init() {
+	registry.Register(registry.Role{
+		Name:        "contrail-analytics",
+		Aliases:     []string{"analytics", "collector"},
+		Description: "Contrail analytics: collector and analytics API config, Sandesh certificates",
+		New: func(k *k8s.K8S) registry.ContrailInit {
+			return &Analytics{K8S: k}
+		},
+	})
+}
+
+// Discover finds the analytics DB from the ready endpoints of the
+// contrail-analytics-db service, or the service named by the owner's
+// contrail-analytics-db-instance label, falling back to its cluster IP.
+func (a *Analytics) Discover() error {
+	name, ok := a.K8S.OwnerLabels["contrail-analytics-db-instance"]
+	if !ok {
+		name = analyticsDBService
+	}
+	var err error
+	a.analyticsDB, err = a.K8S.EndpointAddresses(name, "cql", analyticsDBPort)
+	if err != nil {
+		return err
+	}
+	if len(a.analyticsDB) == 0 {
+		endpoint, found, err := a.K8S.ServiceAddress(name, "cql", analyticsDBPort)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("analytics DB service %s not found", name)
+		}
+		a.analyticsDB = []k8s.Endpoint{endpoint}
+	}
+	a.collector, err = a.K8S.PodEndpoint(k8s.CollectorPort)
+	if err != nil {
+		return err
+	}
+	a.K8S.AddFact("analyticsDB", k8s.JoinEndpoints(a.analyticsDB, " "))
+	return nil
+}
+
+func (a *Analytics) Validate() error {
+	if a.collector.IsZero() {
+		return fmt.Errorf("pod has no IP")
+	}
+	if len(a.analyticsDB) == 0 {
+		return fmt.Errorf("no analytics DB endpoint found")
+	}
+	return nil
+}
+
+func (a *Analytics) Render() (*k8s.Rendered, error) {
+	sandesh := `[SANDESH]
+introspect_ssl_enable=1
+sandesh_ssl_enable=1
+sandesh_keyfile=/etc/contrailkeys/` + a.K8S.KeyName() + `
+sandesh_certfile=/etc/contrailkeys/` + a.K8S.PemName() + `
+sandesh_ca_cert=/var/run/secrets/kubernetes.io/serviceaccount/ca.crt`
+
+	collectorConfig := `[DEFAULT]
+hostname=` + a.K8S.Hostname + `
+hostip=` + a.K8S.PodIP + `
+http_server_port=8089
+log_level=SYS_DEBUG
+log_file=/var/log/contrail/contrail-collector.log
+cassandra_server_list=` + k8s.JoinEndpoints(a.analyticsDB, " ") + `
+[COLLECTOR]
+server=` + a.collector.Host() + `
+port=` + strconv.Itoa(int(a.collector.Port)) + `
+` + sandesh
+
+	analyticsAPIConfig := `[DEFAULTS]
+host_ip=` + a.K8S.PodIP + `
+http_server_port=8090
+rest_api_ip=0.0.0.0
+rest_api_port=` + strconv.Itoa(analyticsAPIPort) + `
+log_level=SYS_DEBUG
+log_file=/var/log/contrail/contrail-analytics-api.log
+cassandra_server_list=` + k8s.JoinEndpoints(a.analyticsDB, " ") + `
+collectors=` + a.collector.String() + `
+` + sandesh
+
+	return &k8s.Rendered{
+		Config: map[string]string{
+			a.collectorConfigName():    collectorConfig,
+			a.analyticsAPIConfigName(): analyticsAPIConfig,
+		},
+		Certificate: true,
+	}, nil
+}
+
+func (a *Analytics) collectorConfigName() string {
+	return "contrail-collector-" + a.K8S.Hostname + ".conf"
+}
+
+func (a *Analytics) analyticsAPIConfigName() string {
+	return "contrail-analytics-api-" + a.K8S.Hostname + ".conf"
+}
+
+func (a *Analytics) Teardown() error {
+	return a.K8S.Teardown([]string{a.collectorConfigName(), a.analyticsAPIConfigName()}, nil)
+}
@@ -0,0 +1,244 @@
+// Package app is the contrail-init command line. The contrail-init binary
+// and downstream builds adding their own roles run it from their main
+// package:
+//
+//	import (
+//		"github.com/michaelhenkel/contrail-init/app"
+//		_ "example.com/corp/role"
+//	)
+//
+//	func main() {
+//		app.Main()
+//	}
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/michaelhenkel/contrail-init/gc"
+	"github.com/michaelhenkel/contrail-init/registry"
+	"github.com/michaelhenkel/contrail-init/vrouter"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	k8sv1 "github.com/michaelhenkel/contrail-init/k8s"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	caCommonName         = "contrail-signer"
+	caCertValidityPeriod = 10 * 365 * 24 * time.Hour // 10 years
+	certValidityPeriod   = 10 * 365 * 24 * time.Hour // 10 years
+	caCertKeyLength      = 2048
+	certKeyLength        = 2048
+)
+
+var err error
+
+// Main parses the flags and runs the command, with the built-in roles and
+// every role registered by the packages the main package imports.
+func Main() {
+	dryRun := flag.Bool("dry-run", false, "render the config for this pod, print a diff against the live ConfigMap and Secret and only send server-side dry-run writes")
+	output := flag.String("output", k8sv1.OutputShared, "config output mode: "+k8sv1.OutputShared+" merges all hosts into <owner>-configmap, "+k8sv1.OutputImmutable+" writes content-addressed immutable ConfigMaps referenced from <owner>-active")
+	retain := flag.Int("retain", k8sv1.DefaultRetain, "number of immutable config versions kept per host")
+	naming := flag.String("naming", k8sv1.NamingShared, "ConfigMap and Secret naming: "+k8sv1.NamingShared+" uses <owner>-configmap/<owner>-secret, "+k8sv1.NamingPerNode+" uses one <owner>-<host> ConfigMap and Secret per node")
+	configDir := flag.String("config-dir", "", "also write the rendered config files to this directory")
+	keyDir := flag.String("key-dir", "", "also write the keys and certificates to this directory")
+	role := flag.String("role", "", "comma separated roles to generate, e.g. control,dns,named; defaults to the "+k8sv1.RolesLabel+" label in "+k8sv1.PodInfoLabelsPath+", then APP, then the app label, then the owner's labels")
+	owner := flag.String("owner", os.Getenv("OWNER"), "owner workload as <resource>/<name>, e.g. ds/contrail-vrouter, instead of walking the pod's ownerReferences (env OWNER)")
+	hostname := flag.String("hostname", "", "host to generate or tear down, defaults to the node of this pod")
+	grace := flag.Duration("grace", 10*time.Minute, "gc: how long a node must be gone before its keys are pruned")
+	networkSnapshot := flag.String("network-snapshot", "", "discover the vrouter network from a snapshot recorded with the snapshot command instead of this node")
+	version := flag.Bool("version", false, "print the generator version and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] [command]
+
+Commands:
+  init      run all phases for this pod (default)
+  discover  print the facts the config is rendered from
+  validate  check the discovered facts
+  render    print the rendered config without writing anything
+  apply     write the config, certificates and pod labels
+  verify    check that the rendered config, certificates and labels are live
+  diff      apply with -dry-run
+  gc        prune the keys of removed nodes from the generated ConfigMaps and Secrets
+  teardown  remove this host's config, keys, CSRs and pod labels, e.g. from a preStop hook
+  snapshot  print this node's links, addresses and routes as JSON for -network-snapshot
+
+Roles:
+`, os.Args[0])
+		for _, role := range registry.Roles() {
+			fmt.Fprintf(flag.CommandLine.Output(), "  %-18s %s (aliases: %s)\n", role.Name, role.Description, strings.Join(role.Aliases, ", "))
+		}
+		fmt.Fprintf(flag.CommandLine.Output(), "\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *version {
+		fmt.Println(k8sv1.Version)
+		return
+	}
+	if *output != k8sv1.OutputShared && *output != k8sv1.OutputImmutable {
+		fmt.Printf("unsupported output mode %q, %s/%s are supported\n", *output, k8sv1.OutputShared, k8sv1.OutputImmutable)
+		os.Exit(1)
+	}
+	if *naming != k8sv1.NamingShared && *naming != k8sv1.NamingPerNode {
+		fmt.Printf("unsupported naming %q, %s/%s are supported\n", *naming, k8sv1.NamingShared, k8sv1.NamingPerNode)
+		os.Exit(1)
+	}
+	if flag.Arg(0) == "snapshot" {
+		snapshot, err := vrouter.HostNetwork{}.Snapshot()
+		if err != nil {
+			panic(err)
+		}
+		if err := vrouter.WriteSnapshot(os.Stdout, snapshot); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	namespace := os.Getenv("NAMESPACE")
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		panic(err)
+	}
+
+	command := flag.Arg(0)
+	phases, isPhase := commandPhases[command]
+	switch {
+	case isPhase, command == "teardown":
+	case command == "gc":
+		collector := &gc.Collector{
+			ClientSet: clientset,
+			Namespace: namespace,
+			Grace:     *grace,
+			DryRun:    *dryRun,
+		}
+		if err := collector.Run(stopOnSignal()); err != nil {
+			panic(err)
+		}
+		return
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+	if command == "diff" {
+		*dryRun = true
+	}
+
+	ctx := context.Background()
+	masterLabel := metav1.ListOptions{
+		LabelSelector: "node-role.kubernetes.io/master=",
+	}
+	masterNodeList, err := clientset.CoreV1().Nodes().List(ctx, masterLabel)
+	if err != nil {
+		panic(err)
+	}
+	var masterAddresses []string
+	for _, node := range masterNodeList.Items {
+		for _, address := range node.Status.Addresses {
+			if address.Type == v1.NodeHostName {
+				masterAddresses = append(masterAddresses, address.Address)
+			}
+		}
+	}
+
+	/*
+		kubernetesService, err := clientset.CoreV1().Services("default").Get(ctx, "kubernetes", metav1.GetOptions{})
+		if err != nil {
+			panic(err)
+		}
+		pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, os.Getenv("PODNAME"), metav1.GetOptions{})
+		if err != nil {
+			panic(err)
+		}
+
+		k8s := &k8s.K8S{
+			ClusterIP:   kubernetesService.Spec.ClusterIP,
+			ClusterPort: kubernetesService.Spec.Ports[0].Port,
+			Namespace:   namespace,
+			Hostname:    os.Getenv("HOSTNAME"),
+			ClientSet:   clientset,
+			Service:     kubernetesService,
+			PodName:     os.Getenv("PODNAME"),
+			Type:        os.Getenv("APP"),
+			PodIP:       os.Getenv("PODIP"),
+			Pod:         pod,
+		}
+	*/
+	k8s, err := k8sv1.New(config, clientset, namespace, *owner)
+	if err != nil {
+		panic(err)
+	}
+	k8s.DryRun = *dryRun
+	k8s.Output = *output
+	k8s.Retain = *retain
+	k8s.Naming = *naming
+	k8s.ConfigDir = *configDir
+	k8s.KeyDir = *keyDir
+	k8s.NetworkSnapshot = *networkSnapshot
+	if *hostname != "" {
+		k8s.Hostname = *hostname
+	}
+
+	roleNames, err := k8s.ResolveRoles(*role)
+	if err != nil {
+		panic(err)
+	}
+	var roles []roleInit
+	var canonical []string
+	for _, name := range roleNames {
+		contrailRole, ok := registry.Lookup(name)
+		if !ok {
+			fmt.Printf("unsupported role %q\n\n", name)
+			flag.Usage()
+			os.Exit(1)
+		}
+		canonical = append(canonical, contrailRole.Name)
+		roles = append(roles, roleInit{name: contrailRole.Name, init: contrailRole.New(k8s)})
+	}
+	k8s.Type = strings.Join(canonical, ".")
+
+	if command == "teardown" {
+		var results []roleResult
+		for _, role := range roles {
+			results = append(results, roleResult{role: role.name, phase: "teardown", err: role.init.Teardown()})
+		}
+		if err := report(results); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := runPhases(roles, k8s, phases); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func stopOnSignal() <-chan struct{} {
+	stopCh := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stopCh)
+	}()
+	return stopCh
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	k8sv1 "github.com/michaelhenkel/contrail-init/k8s"
+	"github.com/michaelhenkel/contrail-init/registry"
+)
+
+// commandPhases maps a command to the phases it runs. Every phase runs
+// the phases it depends on first; verify checks the live state without
+// applying.
+var commandPhases = map[string][]string{
+	"":         {"discover", "validate", "render", "apply", "verify"},
+	"init":     {"discover", "validate", "render", "apply", "verify"},
+	"diff":     {"discover", "validate", "render", "apply"},
+	"discover": {"discover"},
+	"validate": {"discover", "validate"},
+	"render":   {"discover", "validate", "render"},
+	"apply":    {"discover", "validate", "render", "apply"},
+	"verify":   {"discover", "validate", "render", "verify"},
+}
+
+// roleInit is a role instance taking part in a run.
+type roleInit struct {
+	name string
+	init registry.ContrailInit
+}
+
+// roleResult is one line of the consolidated report.
+type roleResult struct {
+	role  string
+	phase string
+	err   error
+}
+
+// runPhases runs all roles through phases. The roles share one fact
+// discovery, their rendered results are merged and applied and verified
+// once. A failing role does not stop the other roles from being
+// discovered, validated and rendered, but nothing is applied unless all
+// roles rendered.
+func runPhases(roles []roleInit, k8s *k8sv1.K8S, phases []string) error {
+	var results []roleResult
+	rendered := map[string]*k8sv1.Rendered{}
+	failed := map[string]bool{}
+	for _, phase := range phases {
+		switch phase {
+		case "discover", "validate", "render":
+			for _, role := range roles {
+				if failed[role.name] {
+					continue
+				}
+				var err error
+				switch phase {
+				case "discover":
+					err = role.init.Discover()
+				case "validate":
+					err = role.init.Validate()
+				case "render":
+					rendered[role.name], err = role.init.Render()
+				}
+				results = append(results, roleResult{role: role.name, phase: phase, err: err})
+				if err != nil {
+					failed[role.name] = true
+				}
+			}
+		case "apply", "verify":
+			if len(failed) > 0 {
+				results = append(results, roleResult{role: "all", phase: phase, err: fmt.Errorf("skipped, not all roles rendered")})
+				continue
+			}
+			merged, err := mergeRendered(roles, rendered)
+			if err == nil {
+				if phase == "apply" {
+					err = k8s.Apply(merged)
+				} else {
+					err = k8s.Verify(merged)
+				}
+			}
+			results = append(results, roleResult{role: "all", phase: phase, err: err})
+			if err != nil {
+				failed["all"] = true
+			}
+		}
+	}
+
+	if len(failed) == 0 {
+		printPhaseOutput(roles, k8s, rendered, phases[len(phases)-1])
+	}
+	return report(results)
+}
+
+// mergeRendered combines the results of all roles. Two roles must not
+// render the same config file or pod label with different content.
+func mergeRendered(roles []roleInit, rendered map[string]*k8sv1.Rendered) (*k8sv1.Rendered, error) {
+	merged := &k8sv1.Rendered{
+		Config:       map[string]string{},
+		SecretConfig: map[string]string{},
+		PodLabels:    map[string]string{},
+	}
+	for _, role := range roles {
+		r := rendered[role.name]
+		for name, content := range r.Config {
+			if existing, ok := merged.Config[name]; ok && existing != content {
+				return nil, fmt.Errorf("roles render different content for %s", name)
+			}
+			merged.Config[name] = content
+		}
+		for name, content := range r.SecretConfig {
+			if existing, ok := merged.SecretConfig[name]; ok && existing != content {
+				return nil, fmt.Errorf("roles render different content for %s", name)
+			}
+			merged.SecretConfig[name] = content
+		}
+		for key, value := range r.PodLabels {
+			if existing, ok := merged.PodLabels[key]; ok && existing != value {
+				return nil, fmt.Errorf("roles set pod label %s to %q and %q", key, existing, value)
+			}
+			merged.PodLabels[key] = value
+		}
+		merged.Certificate = merged.Certificate || r.Certificate
+		for _, name := range r.SharedSecrets {
+			if !containsString(merged.SharedSecrets, name) {
+				merged.SharedSecrets = append(merged.SharedSecrets, name)
+			}
+		}
+	}
+	return merged, nil
+}
+
+func printPhaseOutput(roles []roleInit, k8s *k8sv1.K8S, rendered map[string]*k8sv1.Rendered, last string) {
+	switch last {
+	case "discover":
+		facts := k8s.AllFacts()
+		var names []string
+		for name := range facts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s=%s\n", name, facts[name])
+		}
+	case "render":
+		for _, role := range roles {
+			r := rendered[role.name]
+			var names []string
+			for name := range r.Config {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Printf("==> %s: %s <==\n%s\n", role.name, name, r.Config[name])
+			}
+			names = nil
+			for name := range r.SecretConfig {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Printf("%s: secret config %s\n", role.name, name)
+			}
+			for key, value := range r.PodLabels {
+				fmt.Printf("%s: pod label %s=%s\n", role.name, key, value)
+			}
+			if r.Certificate {
+				fmt.Printf("%s: certificate for %s\n", role.name, k8s.Hostname)
+			}
+			for _, name := range r.SharedSecrets {
+				fmt.Printf("%s: shared secret %s\n", role.name, name)
+			}
+		}
+	}
+}
+
+// report prints one line per role and phase and returns an error listing
+// all failures.
+func report(results []roleResult) error {
+	var failures []string
+	fmt.Println("result:")
+	for _, result := range results {
+		status := "ok"
+		if result.err != nil {
+			status = result.err.Error()
+			failures = append(failures, result.role+" "+result.phase+": "+status)
+		}
+		fmt.Printf("  %-20s %-9s %s\n", result.role, result.phase, status)
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d steps failed: %s", len(failures), len(results), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+package app
+
+// Built-in roles. Further roles register themselves when their package is
+// imported here or from the main package calling Main.
+import (
+	_ "github.com/michaelhenkel/contrail-init/analytics"
+	_ "github.com/michaelhenkel/contrail-init/cni"
+	_ "github.com/michaelhenkel/contrail-init/control"
+	_ "github.com/michaelhenkel/contrail-init/dns"
+	_ "github.com/michaelhenkel/contrail-init/kubemanager"
+	_ "github.com/michaelhenkel/contrail-init/named"
+	_ "github.com/michaelhenkel/contrail-init/vrouter"
+)
@@ -2,13 +2,33 @@ package cni
 
 import (
 	"github.com/michaelhenkel/contrail-init/k8s"
+	"github.com/michaelhenkel/contrail-init/registry"
 )
 
 type Cni struct {
 	K8S *k8s.K8S
 }
 
-func (c *Cni) CreateConfig() error {
+func init() {
+	registry.Register(registry.Role{
+		Name:        "contrail-cni",
+		Aliases:     []string{"cni"},
+		Description: "Contrail CNI: 10-contrail.conf",
+		New: func(k *k8s.K8S) registry.ContrailInit {
+			return &Cni{K8S: k}
+		},
+	})
+}
+
+func (c *Cni) Discover() error {
+	return nil
+}
+
+func (c *Cni) Validate() error {
+	return nil
+}
+
+func (c *Cni) Render() (*k8s.Rendered, error) {
 	cniConfig := `{
 "cniVersion": "0.3.1",
 "contrail" : {
@@ -24,13 +44,13 @@ func (c *Cni) CreateConfig() error {
 "name": "contrail-k8s-cni",
 "type": "contrail-k8s-cni"
 }`
-	return c.K8S.CreateConfig(cniConfig, "10-contrail.conf")
-}
-
-func (c *Cni) CreateCertificate() error {
-	return c.K8S.CreateCertificate()
+	return &k8s.Rendered{
+		Config:      map[string]string{"10-contrail.conf": cniConfig},
+		Certificate: true,
+	}, nil
 }
 
-func (c *Cni) SetOwnerNameLabel() error {
-	return c.K8S.SetOwnerNameLabel()
+// Teardown keeps 10-contrail.conf, it is shared by all nodes.
+func (c *Cni) Teardown() error {
+	return c.K8S.Teardown(nil, nil)
 }
@@ -1,32 +1,62 @@
 package control
 
 import (
-	"strconv"
+	"fmt"
 
 	"github.com/michaelhenkel/contrail-init/k8s"
+	"github.com/michaelhenkel/contrail-init/registry"
 )
 
 type Control struct {
 	K8S *k8s.K8S
+
+	collectors string
+}
+
+func init() {
+	registry.Register(registry.Role{
+		Name:        "contrail-control",
+		Aliases:     []string{"control"},
+		Description: "Contrail control node: control config and XMPP certificates",
+		New: func(k *k8s.K8S) registry.ContrailInit {
+			return &Control{K8S: k}
+		},
+	})
+}
+
+func (c *Control) Discover() error {
+	var err error
+	c.collectors, err = c.K8S.Collectors()
+	return err
+}
+
+func (c *Control) Validate() error {
+	if c.K8S.APIServer.IsZero() {
+		return fmt.Errorf("kubernetes service has no cluster IP")
+	}
+	return nil
 }
 
-func (c *Control) CreateConfig() error {
+func (c *Control) Render() (*k8s.Rendered, error) {
 	controlConfig := `[DEFAULT]
 log_level=SYS_DEBUG
 hostname=` + c.K8S.Hostname + `
-[CONFIGDB]
+` + k8s.CollectorsSetting(c.collectors) + `[CONFIGDB]
 config_db_use_k8s=1
 config_db_use_ssl=1
-config_db_server_list=` + c.K8S.ClusterIP + `:` + strconv.Itoa(int(c.K8S.ClusterPort)) + `
-config_db_ca_certs=/etc/contrailkeys/contrail-control-pem-` + c.K8S.Hostname + `.pem
+config_db_server_list=` + c.K8S.APIServer.String() + `
+config_db_ca_certs=/etc/contrailkeys/` + c.K8S.PemName() + `
 [SANDESH]`
-	return c.K8S.CreateConfig(controlConfig, "contrail-control-"+c.K8S.Hostname+".conf")
+	return &k8s.Rendered{
+		Config:      map[string]string{c.configName(): controlConfig},
+		Certificate: true,
+	}, nil
 }
 
-func (c *Control) CreateCertificate() error {
-	return c.K8S.CreateCertificate()
+func (c *Control) configName() string {
+	return "contrail-control-" + c.K8S.Hostname + ".conf"
 }
 
-func (c *Control) SetOwnerNameLabel() error {
-	return c.K8S.SetOwnerNameLabel()
+func (c *Control) Teardown() error {
+	return c.K8S.Teardown([]string{c.configName()}, nil)
 }
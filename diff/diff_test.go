@@ -0,0 +1,66 @@
+package diff
+
+import "testing"
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want string
+	}{
+		{
+			name: "identical",
+			from: "a\nb\n",
+			to:   "a\nb\n",
+			want: "",
+		},
+		{
+			name: "created",
+			from: "",
+			to:   "a\nb\n",
+			want: "--- live\n+++ rendered\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "removed",
+			from: "a\n",
+			to:   "",
+			want: "--- live\n+++ rendered\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "changed line with context",
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n",
+			to:   "1\n2\n3\n4\nfive\n6\n7\n8\n",
+			want: "--- live\n+++ rendered\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "separate hunks",
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			to:   "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			want: "--- live\n+++ rendered\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			name: "trailing newline added",
+			from: "x",
+			to:   "x\n",
+			want: "--- live\n+++ rendered\n@@ -1 +1 @@\n-x\n\\ No newline at end of file\n+x\n",
+		},
+		{
+			name: "trailing newline removed",
+			from: "a\nx\n",
+			to:   "a\nx",
+			want: "--- live\n+++ rendered\n@@ -1,2 +1,2 @@\n a\n-x\n+x\n\\ No newline at end of file\n",
+		},
+		{
+			name: "unchanged last line without newline",
+			from: "1\nx",
+			to:   "one\nx",
+			want: "--- live\n+++ rendered\n@@ -1,2 +1,2 @@\n-1\n+one\n x\n\\ No newline at end of file\n",
+		},
+	}
+	for _, test := range tests {
+		if got := Unified("live", "rendered", test.from, test.to); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package dns
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/michaelhenkel/contrail-init/k8s"
+	"github.com/michaelhenkel/contrail-init/registry"
+)
+
+type DNS struct {
+	K8S *k8s.K8S
+
+	collectors string
+	rndcSecret string
+}
+
+func init() {
+	registry.Register(registry.Role{
+		Name:        "contrail-dns",
+		Aliases:     []string{"dns"},
+		Description: "Contrail DNS: dns config, rndc secret and XMPP certificates",
+		New: func(k *k8s.K8S) registry.ContrailInit {
+			return &DNS{K8S: k}
+		},
+	})
+}
+
+// Discover reads the shared rndc secret or generates it if no other host
+// has yet, Apply then creates it.
+func (d *DNS) Discover() error {
+	var err error
+	d.collectors, err = d.K8S.Collectors()
+	if err != nil {
+		return err
+	}
+	d.rndcSecret, err = d.K8S.RndcSecret()
+	return err
+}
+
+func (d *DNS) Validate() error {
+	if d.K8S.APIServer.IsZero() {
+		return fmt.Errorf("kubernetes service has no cluster IP")
+	}
+	if d.rndcSecret == "" {
+		return fmt.Errorf("no rndc secret")
+	}
+	return nil
+}
+
+func (d *DNS) Render() (*k8s.Rendered, error) {
+	dnsConfig := `[DEFAULT]
+log_level=SYS_DEBUG
+log_file=/var/log/contrail/contrail-dns.log
+hostname=` + d.K8S.Hostname + `
+hostip=` + d.K8S.PodIP + `
+` + k8s.CollectorsSetting(d.collectors) + `dns_server_port=` + strconv.Itoa(k8s.DNSPort) + `
+named_config_file=contrail-named.conf
+named_config_directory=/etc/contrail/dns
+named_log_file=/var/log/contrail/contrail-named.log
+rndc_config_file=contrail-rndc.conf
+rndc_secret=` + d.rndcSecret + `
+xmpp_dns_auth_enable=1
+xmpp_server_cert=/etc/contrailkeys/` + d.K8S.PemName() + `
+xmpp_server_key=/etc/contrailkeys/` + d.K8S.KeyName() + `
+xmpp_ca_cert=/var/run/secrets/kubernetes.io/serviceaccount/ca.crt
+[CONFIGDB]
+config_db_use_k8s=1
+config_db_use_ssl=1
+config_db_server_list=` + d.K8S.APIServer.String() + `
+config_db_ca_certs=/etc/contrailkeys/` + d.K8S.PemName() + `
+[SANDESH]`
+	// The config holds the rndc secret, so it goes to the Secret.
+	return &k8s.Rendered{
+		SecretConfig:  map[string]string{d.configName(): dnsConfig},
+		Certificate:   true,
+		SharedSecrets: []string{k8s.RndcSecretName},
+	}, nil
+}
+
+func (d *DNS) configName() string {
+	return "contrail-dns-" + d.K8S.Hostname + ".conf"
+}
+
+func (d *DNS) Teardown() error {
+	return d.K8S.Teardown([]string{d.configName()}, nil)
+}
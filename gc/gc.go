@@ -0,0 +1,330 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/michaelhenkel/contrail-init/k8s"
+)
+
+// Collector prunes the ConfigMap and Secret data of nodes that have been
+// removed from the cluster for longer than the grace period.
+type Collector struct {
+	ClientSet *kubernetes.Clientset
+	Namespace string
+	Grace     time.Duration
+	DryRun    bool
+
+	nodes      corelisters.NodeLister
+	configMaps corelisters.ConfigMapLister
+	secrets    corelisters.SecretLister
+	recorder   record.EventRecorder
+	// missingSince is when a host referenced by generated data was first
+	// seen without a Node object.
+	missingSince map[string]time.Time
+	// hosts are the hosts of the current sweep: the nodes, the removed
+	// nodes and the hosts the certificate keys were written for.
+	hosts map[string]bool
+	mu    sync.Mutex
+}
+
+// Run watches Nodes and the generated ConfigMaps and Secrets until stopCh
+// is closed.
+func (c *Collector) Run(stopCh <-chan struct{}) error {
+	c.missingSince = map[string]time.Time{}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: c.ClientSet.CoreV1().Events(c.Namespace)})
+	defer broadcaster.Shutdown()
+	c.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "contrail-init-gc"})
+
+	nodeFactory := informers.NewSharedInformerFactory(c.ClientSet, 0)
+	nodeInformer := nodeFactory.Core().V1().Nodes()
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if node, ok := obj.(*corev1.Node); ok {
+				fmt.Printf("node %s deleted, pruning its data in %s\n", node.Name, c.Grace)
+				c.mu.Lock()
+				c.missingSince[node.Name] = time.Now()
+				c.mu.Unlock()
+			}
+		},
+	})
+	c.nodes = nodeInformer.Lister()
+
+	managed := labels.SelectorFromSet(labels.Set{k8s.ManagedByLabel: k8s.ManagedBy}).String()
+	dataFactory := informers.NewSharedInformerFactoryWithOptions(c.ClientSet, 0,
+		informers.WithNamespace(c.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = managed
+		}))
+	c.configMaps = dataFactory.Core().V1().ConfigMaps().Lister()
+	c.secrets = dataFactory.Core().V1().Secrets().Lister()
+
+	nodeFactory.Start(stopCh)
+	dataFactory.Start(stopCh)
+	for informer, synced := range nodeFactory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return fmt.Errorf("cannot sync %v cache", informer)
+		}
+	}
+	for informer, synced := range dataFactory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return fmt.Errorf("cannot sync %v cache", informer)
+		}
+	}
+
+	interval := c.Grace / 4
+	if interval < 30*time.Second {
+		interval = 30 * time.Second
+	}
+	wait.Until(func() {
+		if err := c.sweep(); err != nil {
+			fmt.Println("gc:", err)
+		}
+	}, interval, stopCh)
+	return nil
+}
+
+func (c *Collector) sweep() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	nodeList, err := c.nodes.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, node := range nodeList {
+		existing[node.Name] = true
+		delete(c.missingSince, node.Name)
+	}
+
+	configMaps, err := c.configMaps.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	secrets, err := c.secrets.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	c.hosts = map[string]bool{}
+	for host := range existing {
+		c.hosts[host] = true
+	}
+	for host := range c.missingSince {
+		c.hosts[host] = true
+	}
+	for _, secret := range secrets {
+		for key := range secret.Data {
+			if host := certificateHost(key, secret.Labels[k8s.OwnerLabel]); host != "" {
+				c.hosts[host] = true
+			}
+		}
+	}
+
+	for _, configMap := range configMaps {
+		if err := c.pruneConfigMap(configMap, existing); err != nil {
+			fmt.Printf("gc: configmap %s: %v\n", configMap.Name, err)
+		}
+	}
+	for _, secret := range secrets {
+		if err := c.pruneSecret(secret, existing); err != nil {
+			fmt.Printf("gc: secret %s: %v\n", secret.Name, err)
+		}
+	}
+	return nil
+}
+
+// expired reports whether host has been missing for longer than the grace
+// period.
+func (c *Collector) expired(host string, existing map[string]bool) bool {
+	if host == "" || existing[host] {
+		return false
+	}
+	since, ok := c.missingSince[host]
+	if !ok {
+		c.missingSince[host] = time.Now()
+		return false
+	}
+	return time.Since(since) >= c.Grace
+}
+
+// staleKeys returns the keys written for hosts that have expired. The host
+// of a key is taken from its provenance entry or, for keys without one,
+// from its name, see keyHost. Keys that do not carry the writer's
+// hostname, like the shared cni config, are never stale.
+func (c *Collector) staleKeys(meta metav1.ObjectMeta, dataKeys []string, existing map[string]bool) ([]string, error) {
+	entries, err := k8s.Provenances(meta)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, key := range dataKeys {
+		entry, ok := entries[key]
+		host := entry.Host
+		if !ok {
+			host = c.keyHost(key, meta.Labels[k8s.OwnerLabel])
+		}
+		if host != "" && strings.Contains(key, host) && c.expired(host, existing) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// keyHost returns the host a key was written for by its name: the
+// <owner>-key-<host>.pem and <owner>-pem-<host>.pem certificate keys, or
+// <role>-<host>.conf for the longest known host.
+func (c *Collector) keyHost(key string, owner string) string {
+	if host := certificateHost(key, owner); host != "" {
+		return host
+	}
+	if !strings.HasSuffix(key, ".conf") {
+		return ""
+	}
+	var longest string
+	for host := range c.hosts {
+		if strings.HasSuffix(key, "-"+host+".conf") && len(host) > len(longest) {
+			longest = host
+		}
+	}
+	return longest
+}
+
+// certificateHost returns the host of a certificate key of owner.
+func certificateHost(key string, owner string) string {
+	if owner == "" || !strings.HasSuffix(key, ".pem") {
+		return ""
+	}
+	for _, infix := range []string{"-key-", "-pem-"} {
+		if strings.HasPrefix(key, owner+infix) {
+			return strings.TrimSuffix(strings.TrimPrefix(key, owner+infix), ".pem")
+		}
+	}
+	return ""
+}
+
+func (c *Collector) pruneConfigMap(configMap *corev1.ConfigMap, existing map[string]bool) error {
+	ctx := context.Background()
+	if host, ok := configMap.Labels[k8s.HostLabel]; ok {
+		if !c.expired(host, existing) {
+			return nil
+		}
+		if err := c.ClientSet.CoreV1().ConfigMaps(c.Namespace).Delete(ctx, configMap.Name, c.deleteOptions()); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		c.report(configMap, fmt.Sprintf("deleted configmap %s of removed node %s", configMap.Name, host))
+		return nil
+	}
+	var dataKeys []string
+	for key := range configMap.Data {
+		dataKeys = append(dataKeys, key)
+	}
+	keys, err := c.staleKeys(configMap.ObjectMeta, dataKeys, existing)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		live, err := c.ClientSet.CoreV1().ConfigMaps(c.Namespace).Get(ctx, configMap.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			delete(live.Data, key)
+		}
+		if err := k8s.DropProvenance(&live.ObjectMeta, keys); err != nil {
+			return err
+		}
+		_, err = c.ClientSet.CoreV1().ConfigMaps(c.Namespace).Update(ctx, live, c.updateOptions())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	c.report(configMap, "removed keys of removed nodes: "+strings.Join(keys, ", "))
+	return nil
+}
+
+func (c *Collector) pruneSecret(secret *corev1.Secret, existing map[string]bool) error {
+	ctx := context.Background()
+	if host, ok := secret.Labels[k8s.HostLabel]; ok {
+		if !c.expired(host, existing) {
+			return nil
+		}
+		if err := c.ClientSet.CoreV1().Secrets(c.Namespace).Delete(ctx, secret.Name, c.deleteOptions()); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		c.report(secret, fmt.Sprintf("deleted secret %s of removed node %s", secret.Name, host))
+		return nil
+	}
+	var dataKeys []string
+	for key := range secret.Data {
+		dataKeys = append(dataKeys, key)
+	}
+	keys, err := c.staleKeys(secret.ObjectMeta, dataKeys, existing)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		live, err := c.ClientSet.CoreV1().Secrets(c.Namespace).Get(ctx, secret.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			delete(live.Data, key)
+		}
+		if err := k8s.DropProvenance(&live.ObjectMeta, keys); err != nil {
+			return err
+		}
+		_, err = c.ClientSet.CoreV1().Secrets(c.Namespace).Update(ctx, live, c.updateOptions())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	c.report(secret, "removed keys of removed nodes: "+strings.Join(keys, ", "))
+	return nil
+}
+
+func (c *Collector) report(object runtime.Object, message string) {
+	if c.DryRun {
+		fmt.Println("gc (dry-run):", message)
+		return
+	}
+	fmt.Println("gc:", message)
+	c.recorder.Event(object, corev1.EventTypeNormal, "StaleNodeDataPruned", message)
+}
+
+func (c *Collector) deleteOptions() metav1.DeleteOptions {
+	if c.DryRun {
+		return metav1.DeleteOptions{DryRun: []string{metav1.DryRunAll}}
+	}
+	return metav1.DeleteOptions{}
+}
+
+func (c *Collector) updateOptions() metav1.UpdateOptions {
+	if c.DryRun {
+		return metav1.UpdateOptions{DryRun: []string{metav1.DryRunAll}}
+	}
+	return metav1.UpdateOptions{}
+}
@@ -0,0 +1,53 @@
+package gc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/michaelhenkel/contrail-init/k8s"
+)
+
+func TestStaleKeys(t *testing.T) {
+	c := &Collector{
+		Grace: time.Minute,
+		missingSince: map[string]time.Time{
+			"worker-2":   time.Now().Add(-time.Hour),
+			"worker-2-b": time.Now().Add(-time.Hour),
+		},
+		hosts: map[string]bool{"worker-1": true, "worker-2": true, "worker-2-b": true},
+	}
+	existing := map[string]bool{"worker-1": true}
+	meta := metav1.ObjectMeta{
+		Name:   "contrail-vrouter-secret",
+		Labels: map[string]string{k8s.OwnerLabel: "contrail-vrouter"},
+		Annotations: map[string]string{
+			k8s.ProvenanceAnnotation: `{"contrail-vrouter-pem-worker-2-b.pem": {"host": "worker-2-b"}}`,
+		},
+	}
+	dataKeys := []string{
+		"contrail-vrouter-key-worker-1.pem",
+		"contrail-vrouter-pem-worker-1.pem",
+		"contrail-vrouter-key-worker-2.pem",
+		"contrail-vrouter-pem-worker-2-b.pem",
+		"contrail-vrouter-worker-1.conf",
+		"contrail-vrouter-worker-2.conf",
+		"contrail-dns-worker-2-b.conf",
+		"contrail-cni.conf",
+	}
+	keys, err := c.staleKeys(meta, dataKeys, existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"contrail-dns-worker-2-b.conf",
+		"contrail-vrouter-key-worker-2.pem",
+		"contrail-vrouter-pem-worker-2-b.pem",
+		"contrail-vrouter-worker-2.conf",
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("got %q, want %q", keys, want)
+	}
+}
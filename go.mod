@@ -3,7 +3,8 @@ module github.com/michaelhenkel/contrail-init
 go 1.15
 
 require (
-	k8s.io/api v0.19.1
-	k8s.io/apimachinery v0.19.1
-	k8s.io/client-go v0.19.1
+	k8s.io/api v0.20.15
+	k8s.io/apimachinery v0.20.15
+	k8s.io/client-go v0.20.15
+	sigs.k8s.io/yaml v1.2.0
 )
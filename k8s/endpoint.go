@@ -0,0 +1,88 @@
+package k8s
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Endpoint is an address and port as written into the generated configs.
+// A dual-stack endpoint has an address of each family, the primary one
+// first.
+type Endpoint struct {
+	IPs  []net.IP
+	Port int32
+}
+
+// ParseEndpoint parses the addresses of an endpoint. Empty addresses are
+// skipped.
+func ParseEndpoint(port int32, addresses ...string) (Endpoint, error) {
+	endpoint := Endpoint{Port: port}
+	for _, address := range addresses {
+		if address == "" {
+			continue
+		}
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return Endpoint{}, fmt.Errorf("invalid IP address %q", address)
+		}
+		endpoint.IPs = append(endpoint.IPs, ip)
+	}
+	return endpoint, nil
+}
+
+func (e Endpoint) IsZero() bool {
+	return len(e.IPs) == 0
+}
+
+// Host returns the primary address without brackets.
+func (e Endpoint) Host() string {
+	if e.IsZero() {
+		return ""
+	}
+	return e.IPs[0].String()
+}
+
+// String returns the primary host:port, with the host in brackets if it
+// is an IPv6 address.
+func (e Endpoint) String() string {
+	if e.IsZero() {
+		return ""
+	}
+	return net.JoinHostPort(e.Host(), strconv.Itoa(int(e.Port)))
+}
+
+// Family returns the endpoint reduced to its IPv4 or IPv6 address.
+func (e Endpoint) Family(ipv6 bool) (Endpoint, bool) {
+	for _, ip := range e.IPs {
+		if (ip.To4() == nil) == ipv6 {
+			return Endpoint{IPs: []net.IP{ip}, Port: e.Port}, true
+		}
+	}
+	return Endpoint{}, false
+}
+
+// All returns host:port for every address of the endpoint.
+func (e Endpoint) All() []string {
+	var all []string
+	for _, ip := range e.IPs {
+		all = append(all, net.JoinHostPort(ip.String(), strconv.Itoa(int(e.Port))))
+	}
+	return all
+}
+
+// JoinEndpoints formats a list of endpoints with every address of each,
+// e.g. for server lists.
+func JoinEndpoints(endpoints []Endpoint, separator string) string {
+	var all []string
+	for _, endpoint := range endpoints {
+		all = append(all, endpoint.All()...)
+	}
+	return strings.Join(all, separator)
+}
+
+// PodEndpoint returns this pod's addresses of all families with port.
+func (k *K8S) PodEndpoint(port int32) (Endpoint, error) {
+	return ParseEndpoint(port, k.PodIPs...)
+}
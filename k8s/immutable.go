@@ -0,0 +1,165 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// OutputShared merges every host's files into <owner>-configmap.
+	OutputShared = "shared"
+	// OutputImmutable writes each rendered config set to an immutable
+	// ConfigMap named by the hash of its host and content and points
+	// <owner>-active at it.
+	OutputImmutable = "immutable"
+
+	// DefaultRetain is the default number of immutable versions kept per
+	// host, including the active one.
+	DefaultRetain = 5
+)
+
+// ActiveConfigMapName is the pointer ConfigMap mapping each host to its
+// active immutable config version.
+func (k *K8S) ActiveConfigMapName() string {
+	if k.Naming == NamingPerNode {
+		return k.OwnerName + "-" + k.Hostname + "-active"
+	}
+	return k.OwnerName + "-active"
+}
+
+// configSetHash hashes the host along with the data so hosts rendering the
+// same config, e.g. the cni config, each get a version they own.
+func configSetHash(host string, data map[string]string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00", host)
+	for _, name := range sortedConfigNames(data) {
+		fmt.Fprintf(h, "%s\x00%s\x00", name, data[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// createImmutableConfig stores data in an immutable ConfigMap named by the
+// hash of the host and content, makes it the active version for this host and garbage
+// collects versions beyond the retention count.
+func (k *K8S) createImmutableConfig(data map[string]string) error {
+	ctx := context.Background()
+	name := k.OwnerName + "-" + configSetHash(k.Hostname, data)[:10]
+
+	active, err := k.activeConfigName()
+	if err != nil {
+		return err
+	}
+	if k.DryRun {
+		var live map[string]string
+		if active != "" {
+			activeConfigMap, err := k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Get(ctx, active, metav1.GetOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			if err == nil {
+				live = activeConfigMap.Data
+			}
+		}
+		for _, configName := range sortedConfigNames(data) {
+			printDiff(name, configName, live[configName], data[configName])
+		}
+	}
+
+	immutable := true
+	configMap := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: k.objectMeta(name),
+		Data:       data,
+		Immutable:  &immutable,
+	}
+	configMap.Labels[HostLabel] = k.Hostname
+	written := map[string][]byte{}
+	for configName, configData := range data {
+		written[configName] = []byte(configData)
+	}
+	if err := k.stampProvenance(&configMap.ObjectMeta, written); err != nil {
+		return err
+	}
+	_, err = k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Create(ctx, configMap, metav1.CreateOptions{DryRun: k.dryRun()})
+	if err != nil {
+		if !errors.IsAlreadyExists(err) {
+			return err
+		}
+		fmt.Printf("%s: unchanged, skipping write\n", name)
+	}
+	if err := k.writeLocal(k.ConfigDir, data); err != nil {
+		return err
+	}
+
+	if active != name {
+		fmt.Printf("%s/%s: %q -> %q\n", k.ActiveConfigMapName(), k.Hostname, active, name)
+		if err := k.applyConfigMap(k.ActiveConfigMapName(), map[string]string{k.Hostname: name}); err != nil {
+			return err
+		}
+	}
+	return k.pruneImmutableConfig(name)
+}
+
+func (k *K8S) activeConfigName() (string, error) {
+	ctx := context.Background()
+	pointer, err := k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Get(ctx, k.ActiveConfigMapName(), metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return pointer.Data[k.Hostname], nil
+}
+
+// pruneImmutableConfig deletes the oldest config versions of this host
+// beyond the retention count. The active version is always kept.
+func (k *K8S) pruneImmutableConfig(active string) error {
+	ctx := context.Background()
+	retain := k.Retain
+	if retain < 1 {
+		retain = DefaultRetain
+	}
+	selector := labels.SelectorFromSet(labels.Set{
+		OwnerLabel: k.OwnerName,
+		HostLabel:  k.Hostname,
+	})
+	configMapList, err := k.ClientSet.CoreV1().ConfigMaps(k.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+	var versions []corev1.ConfigMap
+	for _, configMap := range configMapList.Items {
+		if configMap.Immutable != nil && *configMap.Immutable {
+			versions = append(versions, configMap)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].Name == active {
+			return true
+		}
+		if versions[j].Name == active {
+			return false
+		}
+		return versions[j].CreationTimestamp.Before(&versions[i].CreationTimestamp)
+	})
+	for i := retain; i < len(versions); i++ {
+		fmt.Printf("%s: pruning config version created %s\n", versions[i].Name, versions[i].CreationTimestamp)
+		err := k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Delete(ctx, versions[i].Name, metav1.DeleteOptions{DryRun: k.dryRun()})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -12,84 +12,106 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"sort"
 	"time"
 
 	"k8s.io/api/certificates/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/michaelhenkel/contrail-init/diff"
 )
 
 type K8S struct {
-	ClusterIP   string
-	ClusterPort int32
+	// APIServer is the kubernetes service in the default namespace.
+	APIServer   Endpoint
 	Namespace   string
 	Hostname    string
-	ClientSet   *kubernetes.Clientset
+	ClientSet   kubernetes.Interface
 	Service     *corev1.Service
 	Pod         *corev1.Pod
 	Type        string
 	OwnerName   string
 	OwnerLabels map[string]string
-	PodName     string
-	PodIP       string
+	// OwnerAnnotations are the annotations of the owner workload.
+	OwnerAnnotations map[string]string
+	// Node is the node of Hostname, see GetNode.
+	Node *corev1.Node
+	// OwnerReference references the owner workload resolved by
+	// SetOwnerNameLabel.
+	OwnerReference *metav1.OwnerReference
+	// OwnerOverride is an explicit owner as <resource>/<name>, e.g.
+	// daemonset.apps/contrail-vrouter, or just a name.
+	OwnerOverride string
+	Metadata      metadata.Interface
+	Mapper        meta.RESTMapper
+	PodName       string
+	PodIP         string
+	// PodIPs holds the pod's address of each family on dual-stack
+	// clusters, the primary PodIP first.
+	PodIPs []string
+	// Facts are role specific inputs the rendered config depends on.
+	// They are hashed into the provenance annotations.
+	Facts map[string]string
+	// DryRun prints what would change and sends all writes as
+	// server-side dry-run requests.
+	DryRun bool
+	// Output selects how rendered config is stored, see OutputShared and
+	// OutputImmutable.
+	Output string
+	// Retain is the number of immutable config versions kept per host.
+	Retain int
+	// Naming selects the ConfigMap and Secret naming strategy, see
+	// NamingShared and NamingPerNode.
+	Naming string
+	// ConfigDir and KeyDir, when set, receive a local copy of the
+	// rendered config files and keys.
+	ConfigDir string
+	KeyDir    string
+	// NetworkSnapshot replays a network snapshot recorded with the
+	// snapshot command instead of discovering this node's network.
+	NetworkSnapshot string
+
+	// newSharedSecrets holds the shared Secrets generated during discovery,
+	// by name, until Apply creates them.
+	newSharedSecrets map[string]map[string][]byte
 }
 
-func (k *K8S) UpdatePOD() error {
-	ctx := context.Background()
-	_, err := k.ClientSet.CoreV1().Pods(k.Namespace).Update(ctx, k.Pod, metav1.UpdateOptions{})
-	if err != nil {
-		return err
+func (k *K8S) dryRun() []string {
+	if k.DryRun {
+		return []string{metav1.DryRunAll}
 	}
 	return nil
 }
 
-func (k *K8S) SetOwnerNameLabel() error {
-	ctx := context.Background()
-	pod, err := k.ClientSet.CoreV1().Pods(k.Namespace).Get(ctx, k.PodName, metav1.GetOptions{})
+// nodeOwnerReference references the node this pod runs on. CSRs are
+// cluster scoped and cannot be owned by the namespaced owner workload, so
+// they are tied to the node they were issued for instead.
+func (k *K8S) nodeOwnerReference() (*metav1.OwnerReference, error) {
+	node, err := k.GetNode()
 	if err != nil {
-		return err
-	}
-	for _, ownerRefernce := range pod.OwnerReferences {
-		switch ownerRefernce.Kind {
-		case "DaemonSet":
-			daemonSet, err := k.ClientSet.AppsV1().DaemonSets(k.Namespace).Get(ctx, ownerRefernce.Name, metav1.GetOptions{})
-			if err != nil {
-				return err
-			}
-			k.OwnerLabels = daemonSet.Labels
-			k.OwnerName = daemonSet.Name
-		case "ReplicaSet":
-			replicaSet, err := k.ClientSet.AppsV1().ReplicaSets(k.Namespace).Get(ctx, ownerRefernce.Name, metav1.GetOptions{})
-			if err != nil {
-				return err
-			}
-			for _, replicaSetOwnerReference := range replicaSet.OwnerReferences {
-				if replicaSetOwnerReference.Kind == "Deployment" {
-					deployment, err := k.ClientSet.AppsV1().Deployments(k.Namespace).Get(ctx, replicaSetOwnerReference.Name, metav1.GetOptions{})
-					if err != nil {
-						return err
-					}
-					k.OwnerLabels = deployment.Labels
-					k.OwnerName = deployment.Name
-				}
-			}
-		case "StatefulSet":
-			statefulSet, err := k.ClientSet.AppsV1().StatefulSets(k.Namespace).Get(ctx, ownerRefernce.Name, metav1.GetOptions{})
-			if err != nil {
-				return err
-			}
-			k.OwnerLabels = statefulSet.Labels
-			k.OwnerName = statefulSet.Name
-		}
+		return nil, err
 	}
-	return nil
+	return ownerReference("v1", "Node", node.ObjectMeta), nil
 }
 
-func New(clientset *kubernetes.Clientset, namespace string) (*K8S, error) {
+// New discovers the pod this process runs in and its owner. owner
+// overrides the owner resolution, see SetOwnerNameLabel.
+func New(config *rest.Config, clientset *kubernetes.Clientset, namespace string, owner string) (*K8S, error) {
 	ctx := context.Background()
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
 	kubernetesService, err := clientset.CoreV1().Services("default").Get(ctx, "kubernetes", metav1.GetOptions{})
 	if err != nil {
 		return nil, err
@@ -99,16 +121,26 @@ func New(clientset *kubernetes.Clientset, namespace string) (*K8S, error) {
 		return nil, err
 	}
 
+	apiServer, err := ParseEndpoint(kubernetesService.Spec.Ports[0].Port, clusterIPs(kubernetesService)...)
+	if err != nil {
+		return nil, err
+	}
+
 	k8s := &K8S{
-		ClusterIP:   kubernetesService.Spec.ClusterIP,
-		ClusterPort: kubernetesService.Spec.Ports[0].Port,
-		Namespace:   namespace,
-		Hostname:    pod.Spec.NodeName,
-		ClientSet:   clientset,
-		Service:     kubernetesService,
-		PodName:     pod.Name,
-		PodIP:       pod.Status.PodIP,
-		Pod:         pod,
+		APIServer: apiServer,
+		Namespace: namespace,
+		Hostname:  pod.Spec.NodeName,
+		ClientSet: clientset,
+		Service:   kubernetesService,
+		PodName:   pod.Name,
+		PodIP:     pod.Status.PodIP,
+		PodIPs:    podIPs(pod),
+		Pod:       pod,
+		Metadata:  metadataClient,
+		Mapper: restmapper.NewShortcutExpander(
+			restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery())),
+			clientset.Discovery()),
+		OwnerOverride: owner,
 	}
 	if err := k8s.SetOwnerNameLabel(); err != nil {
 		return nil, err
@@ -117,60 +149,207 @@ func New(clientset *kubernetes.Clientset, namespace string) (*K8S, error) {
 
 }
 
-func (k *K8S) CreateConfig(configData string, configName string) error {
-	configMap := &corev1.ConfigMap{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "ConfigMap",
-			APIVersion: "v1",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      k.OwnerName + "-configmap",
-			Namespace: k.Namespace,
-		},
-		Data: map[string]string{configName: configData},
+func podIPs(pod *corev1.Pod) []string {
+	var ips []string
+	for _, podIP := range pod.Status.PodIPs {
+		ips = append(ips, podIP.IP)
+	}
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		ips = append(ips, pod.Status.PodIP)
 	}
+	return ips
+}
+
+// CreateConfigSet writes a set of rendered config files in the configured
+// output mode.
+func (k *K8S) CreateConfigSet(data map[string]string) error {
+	if k.Output == OutputImmutable {
+		return k.createImmutableConfig(data)
+	}
+	if err := k.applyConfigMap(k.ConfigMapName(), data); err != nil {
+		return err
+	}
+	return k.writeLocal(k.ConfigDir, data)
+}
 
+// CreateSecretConfig writes config files holding secrets to this host's
+// Secret and KeyDir.
+func (k *K8S) CreateSecretConfig(data map[string]string) error {
+	files := map[string][]byte{}
+	for name, content := range data {
+		files[name] = []byte(content)
+	}
+	if err := k.applySecret(k.SecretName(), files); err != nil {
+		return err
+	}
+	return k.writeLocalSecret(k.KeyDir, files)
+}
+
+// applyConfigMap merges data into the named ConfigMap. Keys whose content
+// is already live are not written again.
+func (k *K8S) applyConfigMap(name string, data map[string]string) error {
 	ctx := context.Background()
-	_, err := k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Get(ctx, configMap.Name, metav1.GetOptions{})
-	if err != nil {
-		if errors.IsNotFound(err) {
-			_, err = k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Create(ctx, configMap, metav1.CreateOptions{})
-			if err != nil {
+	return retry.OnError(retry.DefaultRetry, isWriteConflict, func() error {
+		configMap, err := k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if !errors.IsNotFound(err) {
 				return err
 			}
+			configMap = &corev1.ConfigMap{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "ConfigMap",
+					APIVersion: "v1",
+				},
+				ObjectMeta: k.objectMeta(name),
+			}
+		}
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		changed := map[string][]byte{}
+		for _, configName := range sortedConfigNames(data) {
+			configData := data[configName]
+			live, ok := configMap.Data[configName]
+			if k.DryRun {
+				printDiff(name, configName, live, configData)
+			}
+			if ok && live == configData {
+				fmt.Printf("%s/%s: unchanged, skipping write\n", name, configName)
+				continue
+			}
+			configMap.Data[configName] = configData
+			changed[configName] = []byte(configData)
+		}
+		perHost := k.Naming == NamingPerNode
+		if len(changed) == 0 {
+			if configMap.ResourceVersion == "" || !k.needsAdoption(configMap.ObjectMeta, perHost) {
+				return nil
+			}
+			fmt.Printf("%s: setting labels, annotations and owner reference\n", name)
+		}
+		k.adopt(&configMap.ObjectMeta, perHost)
+		if len(changed) > 0 {
+			if err := k.stampProvenance(&configMap.ObjectMeta, changed); err != nil {
+				return err
+			}
+		}
+		if configMap.ResourceVersion == "" {
+			_, err = k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Create(ctx, configMap, metav1.CreateOptions{DryRun: k.dryRun()})
 		} else {
-			return err
+			_, err = k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Update(ctx, configMap, metav1.UpdateOptions{DryRun: k.dryRun()})
 		}
-	} else {
-		_, err = k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// applySecret merges data into the named Secret. Keys whose content is
+// already live are not written again.
+func (k *K8S) applySecret(name string, data map[string][]byte) error {
+	ctx := context.Background()
+	return retry.OnError(retry.DefaultRetry, isWriteConflict, func() error {
+		secret, err := k.ClientSet.CoreV1().Secrets(k.Namespace).Get(ctx, name, metav1.GetOptions{})
 		if err != nil {
-			return err
+			if !errors.IsNotFound(err) {
+				return err
+			}
+			secret = &corev1.Secret{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "Secret",
+					APIVersion: "v1",
+				},
+				ObjectMeta: k.objectMeta(name),
+			}
 		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		changed := map[string][]byte{}
+		for _, key := range sortedKeys(data) {
+			live, ok := secret.Data[key]
+			switch {
+			case ok && bytes.Equal(live, data[key]):
+				fmt.Printf("%s/%s: unchanged, skipping write\n", name, key)
+				continue
+			case k.DryRun && ok:
+				fmt.Printf("%s/%s: would be rotated\n", name, key)
+			case k.DryRun:
+				fmt.Printf("%s/%s: would be created\n", name, key)
+			}
+			secret.Data[key] = data[key]
+			changed[key] = data[key]
+		}
+		perHost := k.Naming == NamingPerNode
+		if len(changed) == 0 {
+			if secret.ResourceVersion == "" || !k.needsAdoption(secret.ObjectMeta, perHost) {
+				return nil
+			}
+			fmt.Printf("%s: setting labels, annotations and owner reference\n", name)
+		}
+		k.adopt(&secret.ObjectMeta, perHost)
+		if len(changed) > 0 {
+			if err := k.stampProvenance(&secret.ObjectMeta, changed); err != nil {
+				return err
+			}
+		}
+		if secret.ResourceVersion == "" {
+			_, err = k.ClientSet.CoreV1().Secrets(k.Namespace).Create(ctx, secret, metav1.CreateOptions{DryRun: k.dryRun()})
+		} else {
+			_, err = k.ClientSet.CoreV1().Secrets(k.Namespace).Update(ctx, secret, metav1.UpdateOptions{DryRun: k.dryRun()})
+		}
+		return err
+	})
+}
+
+func isWriteConflict(err error) bool {
+	return errors.IsConflict(err) || errors.IsAlreadyExists(err)
+}
+
+func sortedConfigNames(data map[string]string) []string {
+	var names []string
+	for name := range data {
+		names = append(names, name)
 	}
-	return nil
+	sort.Strings(names)
+	return names
+}
+
+func sortedKeys(data map[string][]byte) []string {
+	var keys []string
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func printDiff(objectName string, configName string, live string, rendered string) {
+	d := diff.Unified("a/"+objectName+"/"+configName, "b/"+objectName+"/"+configName, live, rendered)
+	if d == "" {
+		fmt.Printf("%s/%s: no changes\n", objectName, configName)
+		return
+	}
+	fmt.Print(d)
 }
 
 func (k *K8S) CreateCertificate() error {
-	csrRequest, privateKey, err := generateCsr(k.ClusterIP, k.Hostname)
+	csrRequest, privateKey, err := generateCsr(k.APIServer.IPs, k.Hostname)
 	if err != nil {
 		return err
 	}
 
-	secret := &corev1.Secret{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "Secret",
-			APIVersion: "v1",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      k.OwnerName + "-secret",
-			Namespace: k.Namespace,
-		},
-		Data: map[string][]byte{k.OwnerName + "-key-" + k.Hostname + ".pem": privateKey},
-	}
+	secretName := k.SecretName()
+	secretData := map[string][]byte{k.KeyName(): privateKey}
 
+	csrOwner, err := k.nodeOwnerReference()
+	if err != nil {
+		return err
+	}
 	csr := &v1beta1.CertificateSigningRequest{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: k.OwnerName + "-csr-" + k.Hostname,
+			Name:            k.CSRName(),
+			Labels:          k.Labels(true),
+			Annotations:     k.Annotations(),
+			OwnerReferences: []metav1.OwnerReference{*csrOwner},
 		},
 		Spec: v1beta1.CertificateSigningRequestSpec{
 			Groups:  []string{"system:authenticated"},
@@ -185,6 +364,9 @@ func (k *K8S) CreateCertificate() error {
 	}
 
 	ctx := context.Background()
+	if k.DryRun {
+		return k.dryRunCertificate(secretName, secretData, csr)
+	}
 	_, err = k.ClientSet.CertificatesV1beta1().CertificateSigningRequests().Create(ctx, csr, metav1.CreateOptions{})
 	if err != nil {
 		return err
@@ -231,23 +413,13 @@ func (k *K8S) CreateCertificate() error {
 	var pemClient []byte
 	pemClient = append(pemClient, *signedCert...)
 	pemClient = append(pemClient, privateKey...)
-	secret.Data[k.OwnerName+"-pem-"+k.Hostname+".pem"] = pemClient
+	secretData[k.PemName()] = pemClient
 
-	_, err = k.ClientSet.CoreV1().Secrets(k.Namespace).Get(ctx, secret.GetName(), metav1.GetOptions{})
-	if err != nil {
-		if errors.IsNotFound(err) {
-			_, err = k.ClientSet.CoreV1().Secrets(k.Namespace).Create(ctx, secret, metav1.CreateOptions{})
-			if err != nil {
-				return err
-			}
-		} else {
-			return err
-		}
-	} else {
-		_, err = k.ClientSet.CoreV1().Secrets(k.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
-		if err != nil {
-			return err
-		}
+	if err := k.applySecret(secretName, secretData); err != nil {
+		return err
+	}
+	if err := k.writeLocalSecret(k.KeyDir, secretData); err != nil {
+		return err
 	}
 
 	if err = k.ClientSet.CertificatesV1beta1().CertificateSigningRequests().Delete(ctx, csr.Name, metav1.DeleteOptions{}); err != nil {
@@ -257,6 +429,21 @@ func (k *K8S) CreateCertificate() error {
 	return nil
 }
 
+// dryRunCertificate reports which Secret keys a real run would create or
+// rotate. The CSR is never signed in dry-run mode, so the certificate key
+// is sent to the API server with the private key as a placeholder.
+func (k *K8S) dryRunCertificate(secretName string, secretData map[string][]byte, csr *v1beta1.CertificateSigningRequest) error {
+	ctx := context.Background()
+	secretData[k.PemName()] = secretData[k.KeyName()]
+
+	_, err := k.ClientSet.CertificatesV1beta1().CertificateSigningRequests().Create(ctx, csr, metav1.CreateOptions{DryRun: k.dryRun()})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("csr %s: would be created\n", csr.Name)
+	return k.applySecret(secretName, secretData)
+}
+
 func (k *K8S) csrCreated(csr *v1beta1.CertificateSigningRequest) (bool, error) {
 	ctx := context.Background()
 	csr, err := k.ClientSet.CertificatesV1beta1().CertificateSigningRequests().Get(ctx, csr.Name, metav1.GetOptions{})
@@ -283,7 +470,7 @@ func (k *K8S) csrSigned(csr *v1beta1.CertificateSigningRequest) (bool, *[]byte,
 	return true, &signedCert, nil
 }
 
-func generateCsr(ipAddress string, hostname string) ([]byte, []byte, error) {
+func generateCsr(ips []net.IP, hostname string) ([]byte, []byte, error) {
 	certPrivKey, _ := rsa.GenerateKey(rand.Reader, 2048)
 	certPrivKeyPEM := new(bytes.Buffer)
 	pem.Encode(certPrivKeyPEM, &pem.Block{
@@ -306,7 +493,7 @@ func generateCsr(ipAddress string, hostname string) ([]byte, []byte, error) {
 		},
 		DNSNames:       []string{hostname},
 		EmailAddresses: []string{"test@email.com"},
-		IPAddresses:    []net.IP{net.ParseIP(ipAddress)},
+		IPAddresses:    ips,
 	}
 	buf := new(bytes.Buffer)
 	csrBytes, _ := x509.CreateCertificateRequest(rand.Reader, &csrTemplate, certPrivKey)
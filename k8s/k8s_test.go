@@ -0,0 +1,87 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestApplyConfigMapAdopts checks that a ConfigMap created by the manifest
+// gets the standard labels and owner reference even if its data is live.
+func TestApplyConfigMapAdopts(t *testing.T) {
+	data := map[string]string{"contrail-control-worker-1.conf": "[DEFAULT]\n"}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "contrail-control-configmap", Namespace: "contrail", ResourceVersion: "1"},
+		Data:       data,
+	}
+	k := &K8S{
+		Namespace:      "contrail",
+		Hostname:       "worker-1",
+		OwnerName:      "contrail-control",
+		Type:           "contrail-control",
+		OwnerReference: &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "contrail-control", UID: "uid-1"},
+		ClientSet:      fake.NewSimpleClientset(configMap),
+	}
+	if err := k.applyConfigMap(configMap.Name, data); err != nil {
+		t.Fatal(err)
+	}
+	live, err := k.ClientSet.CoreV1().ConfigMaps("contrail").Get(context.Background(), configMap.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range k.Labels(false) {
+		if live.Labels[key] != value {
+			t.Errorf("label %s: got %q, want %q", key, live.Labels[key], value)
+		}
+	}
+	if len(live.OwnerReferences) != 1 || live.OwnerReferences[0].UID != "uid-1" {
+		t.Errorf("got owner references %v", live.OwnerReferences)
+	}
+	if _, ok := live.Annotations[ProvenanceAnnotation]; ok {
+		t.Error("provenance stamped without a data change")
+	}
+}
+
+func TestTeardownPodLabels(t *testing.T) {
+	controller := true
+	daemonSet := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "contrail-vrouter", UID: "uid-ds", Controller: &controller}
+	pod := func(name string, refs ...metav1.OwnerReference) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "contrail",
+				Labels:          map[string]string{"interface": "eth1"},
+				OwnerReferences: refs,
+			},
+			Spec: corev1.PodSpec{NodeName: "worker-1"},
+		}
+	}
+	self := pod("contrail-vrouter-abcde", daemonSet)
+	k := &K8S{
+		Namespace:      "contrail",
+		Hostname:       "worker-1",
+		PodName:        self.Name,
+		Pod:            self,
+		OwnerReference: &daemonSet,
+		ClientSet: fake.NewSimpleClientset(self,
+			pod("contrail-vrouter-fghij", daemonSet),
+			pod("other", metav1.OwnerReference{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "other", UID: "uid-other", Controller: &controller}),
+			pod("bare")),
+	}
+	if err := k.teardownPodLabels([]string{"interface"}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"contrail-vrouter-abcde": false, "contrail-vrouter-fghij": false, "other": true, "bare": true}
+	for name, labeled := range want {
+		live, err := k.ClientSet.CoreV1().Pods("contrail").Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := live.Labels["interface"]; ok != labeled {
+			t.Errorf("pod %s: label present %t, want %t", name, ok, labeled)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package k8s
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// NamingShared writes all hosts into <owner>-configmap and
+	// <owner>-secret.
+	NamingShared = "shared"
+	// NamingPerNode writes one <owner>-<host> ConfigMap and Secret per
+	// node, owned by the owner workload. The owner cannot mount a per-node
+	// name, so pods read their files from ConfigDir and KeyDir instead.
+	NamingPerNode = "per-node"
+
+	// Labels set on every object contrail-init creates.
+	AppLabel       = "app"
+	RoleLabel      = "contrail-init/role"
+	OwnerLabel     = "contrail-init/owner"
+	HostLabel      = "contrail-init/host"
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedBy      = "contrail-init"
+
+	// RoleAnnotation lists the roles that wrote an object, separated by
+	// dots. RoleLabel is only set for a single role, as several roles can
+	// exceed the 63 characters of a label value.
+	RoleAnnotation = "contrail-init/role"
+)
+
+func (k *K8S) ConfigMapName() string {
+	if k.Naming == NamingPerNode {
+		return k.OwnerName + "-" + k.Hostname
+	}
+	return k.OwnerName + "-configmap"
+}
+
+func (k *K8S) SecretName() string {
+	if k.Naming == NamingPerNode {
+		return k.OwnerName + "-" + k.Hostname
+	}
+	return k.OwnerName + "-secret"
+}
+
+// KeyName is the Secret key holding this host's private key.
+func (k *K8S) KeyName() string {
+	return k.OwnerName + "-key-" + k.Hostname + ".pem"
+}
+
+// PemName is the Secret key holding this host's certificate and private
+// key.
+func (k *K8S) PemName() string {
+	return k.OwnerName + "-pem-" + k.Hostname + ".pem"
+}
+
+func (k *K8S) CSRName() string {
+	return k.OwnerName + "-csr-" + k.Hostname
+}
+
+// Labels returns the standard label set. The host label is only set on
+// objects holding a single host's data.
+func (k *K8S) Labels(perHost bool) map[string]string {
+	labels := map[string]string{
+		OwnerLabel:     k.OwnerName,
+		ManagedByLabel: ManagedBy,
+	}
+	if !strings.Contains(k.Type, ".") {
+		labels[RoleLabel] = k.Type
+	}
+	if app, ok := k.OwnerLabels[AppLabel]; ok {
+		labels[AppLabel] = app
+	}
+	if perHost {
+		labels[HostLabel] = k.Hostname
+	}
+	return labels
+}
+
+// objectMeta returns the metadata for ConfigMaps and Secrets created by
+// contrail-init.
+func (k *K8S) objectMeta(name string) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: k.Namespace,
+	}
+	k.adopt(&meta, k.Naming == NamingPerNode)
+	return meta
+}
+
+// Annotations returns the standard annotation set.
+func (k *K8S) Annotations() map[string]string {
+	return map[string]string{RoleAnnotation: k.Type}
+}
+
+// needsAdoption reports whether adopt would change the metadata.
+func (k *K8S) needsAdoption(meta metav1.ObjectMeta, perHost bool) bool {
+	for key, value := range k.Labels(perHost) {
+		if meta.Labels[key] != value {
+			return true
+		}
+	}
+	for key, value := range k.Annotations() {
+		if meta.Annotations[key] != value {
+			return true
+		}
+	}
+	if k.OwnerReference == nil {
+		return false
+	}
+	for _, ref := range meta.OwnerReferences {
+		if ref.UID == k.OwnerReference.UID {
+			return false
+		}
+	}
+	return true
+}
+
+// adopt adds the standard labels, annotations and the owner reference to
+// objects that were created without them, e.g. by the manifest.
+func (k *K8S) adopt(meta *metav1.ObjectMeta, perHost bool) {
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	for key, value := range k.Labels(perHost) {
+		meta.Labels[key] = value
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	for key, value := range k.Annotations() {
+		meta.Annotations[key] = value
+	}
+	if k.OwnerReference == nil {
+		return
+	}
+	for _, ref := range meta.OwnerReferences {
+		if ref.UID == k.OwnerReference.UID {
+			return
+		}
+	}
+	meta.OwnerReferences = append(meta.OwnerReferences, *k.OwnerReference)
+}
+
+func (k *K8S) writeLocal(dir string, data map[string]string) error {
+	files := map[string][]byte{}
+	for name, content := range data {
+		files[name] = []byte(content)
+	}
+	return k.writeLocalFiles(dir, files, 0644)
+}
+
+func (k *K8S) writeLocalSecret(dir string, data map[string][]byte) error {
+	return k.writeLocalFiles(dir, data, 0600)
+}
+
+func (k *K8S) writeLocalFiles(dir string, files map[string][]byte, perm os.FileMode) error {
+	if dir == "" {
+		return nil
+	}
+	for _, name := range sortedKeys(files) {
+		path := filepath.Join(dir, name)
+		if k.DryRun {
+			fmt.Printf("%s: would be written\n", path)
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, files[name], perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// maxOwnerDepth bounds the ownerReference walk, e.g. Pod -> ReplicaSet ->
+// Deployment, or Pod -> operator CR -> parent CR.
+const maxOwnerDepth = 8
+
+// SetOwnerNameLabel resolves the owner workload of the pod. It follows the
+// controller ownerReferences through the built-in workloads, e.g. Pod ->
+// ReplicaSet -> Deployment, and stops before an operator's custom resource
+// so workloads managed by one resource keep their own names. Only pods
+// without a workload owner take the custom resource as owner, following
+// its ownerReferences in turn. OwnerOverride takes precedence.
+func (k *K8S) SetOwnerNameLabel() error {
+	if k.OwnerOverride != "" {
+		return k.setOwnerOverride()
+	}
+	ctx := context.Background()
+	pod, err := k.ClientSet.CoreV1().Pods(k.Namespace).Get(ctx, k.PodName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	var owner *metav1.PartialObjectMetadata
+	ownerIsWorkload := false
+	refs := pod.OwnerReferences
+	for depth := 0; depth < maxOwnerDepth; depth++ {
+		ref := controllerRef(refs)
+		if ref == nil {
+			break
+		}
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil {
+			return err
+		}
+		isWorkload := workloadKinds[gv.WithKind(ref.Kind).GroupKind()]
+		if ownerIsWorkload && !isWorkload {
+			break
+		}
+		ownerIsWorkload = isWorkload
+		object, err := k.getMetadata(gv.WithKind(ref.Kind), ref.Name)
+		if err != nil {
+			return fmt.Errorf("cannot get owner %s %s: %v", ref.Kind, ref.Name, err)
+		}
+		owner = object
+		k.OwnerReference = ownerReference(ref.APIVersion, ref.Kind, object.ObjectMeta)
+		refs = object.OwnerReferences
+	}
+	if owner == nil {
+		return fmt.Errorf("pod %s/%s has no owner, set an explicit owner as <resource>/<name>", k.Namespace, k.PodName)
+	}
+	k.OwnerName = owner.Name
+	k.OwnerLabels = owner.Labels
+	k.OwnerAnnotations = owner.Annotations
+	return nil
+}
+
+// workloadKinds are the built-in controllers of pods.
+var workloadKinds = map[schema.GroupKind]bool{
+	{Group: "apps", Kind: "DaemonSet"}:         true,
+	{Group: "apps", Kind: "Deployment"}:        true,
+	{Group: "apps", Kind: "ReplicaSet"}:        true,
+	{Group: "apps", Kind: "StatefulSet"}:       true,
+	{Group: "batch", Kind: "Job"}:              true,
+	{Group: "batch", Kind: "CronJob"}:          true,
+	{Group: "", Kind: "ReplicationController"}: true,
+}
+
+// controllerRef returns the managing controller, or the only owner of
+// objects whose ownerReferences do not set controller.
+func controllerRef(refs []metav1.OwnerReference) *metav1.OwnerReference {
+	for i := range refs {
+		if refs[i].Controller != nil && *refs[i].Controller {
+			return &refs[i]
+		}
+	}
+	if len(refs) == 1 {
+		return &refs[0]
+	}
+	return nil
+}
+
+// setOwnerOverride uses the owner given as <resource>/<name>, e.g.
+// ds/contrail-vrouter or deployments.apps/contrail-control. A plain name
+// only sets the owner name.
+func (k *K8S) setOwnerOverride() error {
+	parts := strings.SplitN(k.OwnerOverride, "/", 2)
+	if len(parts) == 1 {
+		k.OwnerName = parts[0]
+		k.OwnerLabels = map[string]string{}
+		k.OwnerAnnotations = map[string]string{}
+		return nil
+	}
+	gvk, err := k.Mapper.KindFor(schema.ParseGroupResource(parts[0]).WithVersion(""))
+	if err != nil {
+		return fmt.Errorf("cannot resolve owner %s: %v", k.OwnerOverride, err)
+	}
+	object, err := k.getMetadata(gvk, parts[1])
+	if err != nil {
+		return fmt.Errorf("cannot get owner %s: %v", k.OwnerOverride, err)
+	}
+	k.OwnerName = object.Name
+	k.OwnerLabels = object.Labels
+	k.OwnerAnnotations = object.Annotations
+	k.OwnerReference = ownerReference(gvk.GroupVersion().String(), gvk.Kind, object.ObjectMeta)
+	return nil
+}
+
+func (k *K8S) getMetadata(gvk schema.GroupVersionKind, name string) (*metav1.PartialObjectMetadata, error) {
+	ctx := context.Background()
+	mapping, err := k.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return k.Metadata.Resource(mapping.Resource).Namespace(k.Namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	return k.Metadata.Resource(mapping.Resource).Get(ctx, name, metav1.GetOptions{})
+}
+
+func ownerReference(apiVersion string, kind string, owner metav1.ObjectMeta) *metav1.OwnerReference {
+	return &metav1.OwnerReference{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Name:       owner.Name,
+		UID:        owner.UID,
+	}
+}
@@ -0,0 +1,222 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// Rendered is the output of a role's Render phase. Rendering has no side
+// effects, Apply writes it and Verify checks the written result.
+type Rendered struct {
+	// Config holds the config files by name.
+	Config map[string]string
+	// PodLabels are set on this pod, e.g. for other init containers.
+	PodLabels map[string]string
+	// Certificate requests a private key and signed certificate for this
+	// host.
+	Certificate bool
+	// SecretConfig holds the config files that contain secrets, by name.
+	// They are written to this host's Secret instead of the ConfigMap.
+	SecretConfig map[string]string
+	// SharedSecrets are created if no host has yet, e.g. the rndc key.
+	SharedSecrets []string
+}
+
+// AllFacts returns the discovered facts, including the ones every role
+// shares.
+func (k *K8S) AllFacts() map[string]string {
+	facts := map[string]string{
+		"apiServer": strings.Join(k.APIServer.All(), ","),
+		"namespace": k.Namespace,
+		"hostname":  k.Hostname,
+		"owner":     k.OwnerName,
+		"podIP":     k.PodIP,
+		"podIPs":    strings.Join(k.PodIPs, ","),
+	}
+	for name, value := range k.Facts {
+		facts[name] = value
+	}
+	return facts
+}
+
+// Apply writes the rendered result to the API.
+func (k *K8S) Apply(r *Rendered) error {
+	if err := k.setPodLabels(r.PodLabels); err != nil {
+		return err
+	}
+	for _, name := range r.SharedSecrets {
+		if err := k.createSharedSecret(name); err != nil {
+			return err
+		}
+	}
+	if len(r.Config) > 0 {
+		if err := k.CreateConfigSet(r.Config); err != nil {
+			return err
+		}
+	}
+	if len(r.SecretConfig) > 0 {
+		if err := k.CreateSecretConfig(r.SecretConfig); err != nil {
+			return err
+		}
+	}
+	if r.Certificate {
+		return k.CreateCertificate()
+	}
+	return nil
+}
+
+func (k *K8S) setPodLabels(labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod, err := k.ClientSet.CoreV1().Pods(k.Namespace).Get(ctx, k.PodName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		changed := false
+		for key, value := range labels {
+			if pod.Labels[key] != value {
+				pod.Labels[key] = value
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+		pod, err = k.ClientSet.CoreV1().Pods(k.Namespace).Update(ctx, pod, metav1.UpdateOptions{DryRun: k.dryRun()})
+		if err != nil {
+			return err
+		}
+		k.Pod = pod
+		return nil
+	})
+}
+
+// Verify checks that the rendered result is live: the config files, the
+// secret config files, the host's key and certificate, the shared Secrets and the pod labels.
+func (k *K8S) Verify(r *Rendered) error {
+	if k.DryRun {
+		fmt.Println("verify: skipped in dry-run mode")
+		return nil
+	}
+	ctx := context.Background()
+	var problems []string
+
+	if len(r.Config) > 0 {
+		live, name, err := k.liveConfig()
+		if err != nil {
+			return err
+		}
+		for _, configName := range sortedConfigNames(r.Config) {
+			if content, ok := live[configName]; !ok {
+				problems = append(problems, fmt.Sprintf("%s/%s is missing", name, configName))
+			} else if content != r.Config[configName] {
+				problems = append(problems, fmt.Sprintf("%s/%s differs from the rendered config", name, configName))
+			}
+			if k.ConfigDir != "" {
+				content, err := ioutil.ReadFile(filepath.Join(k.ConfigDir, configName))
+				if err != nil || string(content) != r.Config[configName] {
+					problems = append(problems, fmt.Sprintf("%s does not hold the rendered config", filepath.Join(k.ConfigDir, configName)))
+				}
+			}
+		}
+	}
+
+	if len(r.SecretConfig) > 0 {
+		secret, err := k.ClientSet.CoreV1().Secrets(k.Namespace).Get(ctx, k.SecretName(), metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		for _, configName := range sortedConfigNames(r.SecretConfig) {
+			if err != nil || string(secret.Data[configName]) != r.SecretConfig[configName] {
+				problems = append(problems, fmt.Sprintf("%s/%s does not hold the rendered config", k.SecretName(), configName))
+			}
+		}
+	}
+
+	if r.Certificate {
+		secret, err := k.ClientSet.CoreV1().Secrets(k.Namespace).Get(ctx, k.SecretName(), metav1.GetOptions{})
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				return err
+			}
+			problems = append(problems, fmt.Sprintf("secret %s is missing", k.SecretName()))
+		} else {
+			key, pem := secret.Data[k.KeyName()], secret.Data[k.PemName()]
+			if len(key) == 0 || len(pem) == 0 {
+				problems = append(problems, fmt.Sprintf("%s/%s or %s is missing", k.SecretName(), k.KeyName(), k.PemName()))
+			} else if !bytes.HasSuffix(pem, key) {
+				problems = append(problems, fmt.Sprintf("%s/%s does not contain %s", k.SecretName(), k.PemName(), k.KeyName()))
+			} else if _, err := tls.X509KeyPair(pem, key); err != nil {
+				problems = append(problems, fmt.Sprintf("%s/%s: %v", k.SecretName(), k.PemName(), err))
+			}
+		}
+	}
+
+	for _, name := range r.SharedSecrets {
+		exists, err := k.SharedSecretExists(name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			problems = append(problems, fmt.Sprintf("secret %s is missing", name))
+		}
+	}
+
+	if len(r.PodLabels) > 0 {
+		pod, err := k.ClientSet.CoreV1().Pods(k.Namespace).Get(ctx, k.PodName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for key, value := range r.PodLabels {
+			if pod.Labels[key] != value {
+				problems = append(problems, fmt.Sprintf("pod label %s=%q is not set", key, value))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("verify failed: %v", problems)
+	}
+	fmt.Println("verify: ok")
+	return nil
+}
+
+// liveConfig returns the data of the ConfigMap this host's config is
+// written to in the configured output mode.
+func (k *K8S) liveConfig() (map[string]string, string, error) {
+	ctx := context.Background()
+	name := k.ConfigMapName()
+	if k.Output == OutputImmutable {
+		active, err := k.activeConfigName()
+		if err != nil {
+			return nil, "", err
+		}
+		if active == "" {
+			return nil, k.ActiveConfigMapName() + "/" + k.Hostname, nil
+		}
+		name = active
+	}
+	configMap, err := k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, name, nil
+		}
+		return nil, "", err
+	}
+	return configMap.Data, name, nil
+}
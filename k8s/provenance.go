@@ -0,0 +1,134 @@
+package k8s
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Version is the generator version recorded in the provenance annotations.
+// It is set at build time with
+// -ldflags "-X github.com/michaelhenkel/contrail-init/k8s.Version=<version>".
+var Version = "dev"
+
+const (
+	annotationPrefix = "contrail-init/"
+	// GeneratorVersionAnnotation holds the version of the last writer.
+	GeneratorVersionAnnotation = annotationPrefix + "generator-version"
+	// LastWriterAnnotation holds the pod and time of the last write.
+	LastWriterAnnotation = annotationPrefix + "last-writer"
+	// ProvenanceAnnotation holds a JSON map from data key to Provenance.
+	ProvenanceAnnotation = annotationPrefix + "provenance"
+
+	// maxProvenanceSize bounds ProvenanceAnnotation well below the 256 KiB
+	// all annotations of an object share. Shared ConfigMaps and Secrets of
+	// large clusters exceed it, and their provenance is not recorded.
+	maxProvenanceSize = 128 << 10
+)
+
+// Provenance records which host produced a ConfigMap or Secret key and
+// from what. It is kept small as shared objects hold an entry for every
+// key of every host; the hashes are truncated to 64 bits.
+type Provenance struct {
+	Host        string `json:"host"`
+	ContentHash string `json:"contentHash"`
+	FactsHash   string `json:"factsHash"`
+}
+
+func (k *K8S) AddFact(name string, value string) {
+	if k.Facts == nil {
+		k.Facts = map[string]string{}
+	}
+	k.Facts[name] = value
+}
+
+// FactsHash hashes the discovered inputs the rendered config is based on.
+func (k *K8S) FactsHash() string {
+	facts := k.AllFacts()
+	var names []string
+	for name := range facts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\n", name, facts[name])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Provenances returns the provenance entries recorded on an object.
+func Provenances(meta metav1.ObjectMeta) (map[string]Provenance, error) {
+	entries := map[string]Provenance{}
+	if value, ok := meta.Annotations[ProvenanceAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &entries); err != nil {
+			return nil, fmt.Errorf("cannot parse %s annotation of %s: %v", ProvenanceAnnotation, meta.Name, err)
+		}
+	}
+	return entries, nil
+}
+
+// stampProvenance records provenance for the written keys, keeping the
+// entries of keys written by other pods. When the entries outgrow
+// maxProvenanceSize, the annotation is dropped rather than failing the
+// write.
+func (k *K8S) stampProvenance(meta *metav1.ObjectMeta, written map[string][]byte) error {
+	entries, err := Provenances(*meta)
+	if err != nil {
+		return err
+	}
+	factsHash := k.FactsHash()
+	for key, content := range written {
+		entries[key] = Provenance{
+			Host:        k.Hostname,
+			ContentHash: contentHash(content),
+			FactsHash:   factsHash,
+		}
+	}
+	value, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[GeneratorVersionAnnotation] = Version
+	meta.Annotations[LastWriterAnnotation] = k.Namespace + "/" + k.PodName + " " + time.Now().UTC().Format(time.RFC3339)
+	if len(value) > maxProvenanceSize {
+		fmt.Printf("%s: provenance of %d keys exceeds %d bytes, not recording it; use -naming=%s on large clusters\n", meta.Name, len(entries), maxProvenanceSize, NamingPerNode)
+		delete(meta.Annotations, ProvenanceAnnotation)
+		return nil
+	}
+	meta.Annotations[ProvenanceAnnotation] = string(value)
+	return nil
+}
+
+// DropProvenance removes the provenance entries of deleted keys.
+func DropProvenance(meta *metav1.ObjectMeta, keys []string) error {
+	entries, err := Provenances(*meta)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		delete(entries, key)
+	}
+	value, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	meta.Annotations[ProvenanceAnnotation] = string(value)
+	return nil
+}
@@ -0,0 +1,52 @@
+package k8s
+
+import (
+	"fmt"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStampProvenance(t *testing.T) {
+	k := &K8S{Namespace: "contrail", PodName: "contrail-vrouter-abcde", Hostname: "worker-1", OwnerName: "contrail-vrouter"}
+	meta := metav1.ObjectMeta{Name: "contrail-vrouter-secret"}
+	if err := k.stampProvenance(&meta, map[string][]byte{k.PemName(): []byte("pem")}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := Provenances(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := entries[k.PemName()]
+	if entry.Host != "worker-1" || entry.ContentHash != contentHash([]byte("pem")) || entry.FactsHash == "" {
+		t.Errorf("got entry %+v", entry)
+	}
+	if meta.Annotations[LastWriterAnnotation] == "" {
+		t.Errorf("%s not set", LastWriterAnnotation)
+	}
+}
+
+// TestStampProvenanceSizeGuard stamps the key and certificate of many
+// hosts into one shared Secret.
+func TestStampProvenanceSizeGuard(t *testing.T) {
+	meta := metav1.ObjectMeta{Name: "contrail-vrouter-secret"}
+	for host := 0; host < 1000; host++ {
+		k := &K8S{Namespace: "contrail", Hostname: fmt.Sprintf("worker-%d", host), OwnerName: "contrail-vrouter"}
+		written := map[string][]byte{k.KeyName(): []byte("key"), k.PemName(): []byte("pem")}
+		if err := k.stampProvenance(&meta, written); err != nil {
+			t.Fatalf("host %d: %v", host, err)
+		}
+		if size := len(meta.Annotations[ProvenanceAnnotation]); size > maxProvenanceSize {
+			t.Fatalf("host %d: annotation of %d bytes", host, size)
+		}
+		if host == 399 {
+			entries, err := Provenances(meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 800 {
+				t.Fatalf("got %d entries for 400 hosts, want 800", len(entries))
+			}
+		}
+	}
+}
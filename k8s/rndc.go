@@ -0,0 +1,122 @@
+package k8s
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RndcSecretName is the Secret holding the rndc key shared by all
+	// contrail-dns and contrail-named instances in the namespace.
+	RndcSecretName = "contrail-rndc"
+	// RndcKey is the key statement named and rndc include.
+	RndcKey = "rndc.key"
+	// RndcSecretKey is the bare secret of the key for contrail-dns.
+	RndcSecretKey = "rndc.secret"
+	// RndcKeyName names the key in RndcKey.
+	RndcKeyName = "rndc-key"
+	// RndcDir is where the manifests mount the RndcSecretName Secret.
+	RndcDir = "/etc/contrailrndc"
+)
+
+func newRndcSecret() (map[string][]byte, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	secret := base64.StdEncoding.EncodeToString(random)
+	key := `key "` + RndcKeyName + `" {
+    algorithm hmac-md5;
+    secret "` + secret + `";
+};
+`
+	return map[string][]byte{
+		RndcKey:       []byte(key),
+		RndcSecretKey: []byte(secret),
+	}, nil
+}
+
+// RndcSecret returns the shared rndc secret. If no host has created the
+// Secret yet, a new one is generated, and Apply creates the Secret with it
+// before writing any config, see createSharedSecret.
+func (k *K8S) RndcSecret() (string, error) {
+	if data, ok := k.newSharedSecrets[RndcSecretName]; ok {
+		return string(data[RndcSecretKey]), nil
+	}
+	ctx := context.Background()
+	secret, err := k.ClientSet.CoreV1().Secrets(k.Namespace).Get(ctx, RndcSecretName, metav1.GetOptions{})
+	if err == nil {
+		if value := secret.Data[RndcSecretKey]; len(value) > 0 {
+			k.AddFact("rndcSecret", RndcSecretName)
+			return string(value), nil
+		}
+		return "", fmt.Errorf("secret %s has no %s", RndcSecretName, RndcSecretKey)
+	}
+	if !errors.IsNotFound(err) {
+		return "", err
+	}
+	data, err := newRndcSecret()
+	if err != nil {
+		return "", err
+	}
+	if k.newSharedSecrets == nil {
+		k.newSharedSecrets = map[string]map[string][]byte{}
+	}
+	k.newSharedSecrets[RndcSecretName] = data
+	k.AddFact("rndcSecret", RndcSecretName+" (created on apply)")
+	return string(data[RndcSecretKey]), nil
+}
+
+// SharedSecretExists reports whether a shared Secret was created already.
+func (k *K8S) SharedSecretExists(name string) (bool, error) {
+	ctx := context.Background()
+	_, err := k.ClientSet.CoreV1().Secrets(k.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// createSharedSecret stores a shared Secret generated during discovery.
+// When several hosts race to create it, the one stored first wins and the
+// others fail, as their rendered config holds a secret nobody else uses;
+// the next run discovers the stored one. The Secret has no owner as it
+// outlives any single workload.
+func (k *K8S) createSharedSecret(name string) error {
+	data, ok := k.newSharedSecrets[name]
+	if !ok {
+		return nil
+	}
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: k.Namespace,
+			Labels:    map[string]string{ManagedByLabel: ManagedBy},
+		},
+		Data: data,
+	}
+	ctx := context.Background()
+	_, err := k.ClientSet.CoreV1().Secrets(k.Namespace).Create(ctx, secret, metav1.CreateOptions{DryRun: k.dryRun()})
+	if errors.IsAlreadyExists(err) {
+		return fmt.Errorf("secret %s was created by another host since discovery, rerun to use it", name)
+	}
+	if err != nil {
+		return err
+	}
+	if k.DryRun {
+		fmt.Printf("%s: would be created\n", name)
+	} else {
+		delete(k.newSharedSecrets, name)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package k8s
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PodInfoLabelsPath is where the manifests mount the pod labels through
+// the downward API.
+const PodInfoLabelsPath = "/etc/podinfo/labels"
+
+// RolesLabel lists several roles for one pod, separated by dots as label
+// values cannot hold commas, e.g. contrail-init/roles: control.dns.named.
+// It takes precedence over the app label and APP, which the manifests set
+// from the app label.
+const RolesLabel = "contrail-init/roles"
+
+// ResolveRoles returns the roles to run from, in order, the role flag, the
+// RolesLabel in the downward API labels file, the APP environment
+// variable, the app label in the labels file and the labels of the owner.
+// Flag and APP take a comma separated list.
+func (k *K8S) ResolveRoles(flagRole string) ([]string, error) {
+	podLabels, err := ReadPodInfoLabels(PodInfoLabelsPath)
+	if err != nil {
+		return nil, err
+	}
+	return k.resolveRoles(flagRole, os.Getenv("APP"), podLabels)
+}
+
+func (k *K8S) resolveRoles(flagRole string, app string, podLabels map[string]string) ([]string, error) {
+	sources := []struct {
+		name  string
+		roles []string
+	}{
+		{"flag", splitRoles(flagRole, ",")},
+		{PodInfoLabelsPath + " label " + RolesLabel, splitRoles(podLabels[RolesLabel], ".")},
+		{"APP environment variable", splitRoles(app, ",")},
+		{PodInfoLabelsPath, labelRoles(podLabels)},
+		{"owner " + k.OwnerName + " labels", labelRoles(k.OwnerLabels)},
+	}
+	for _, source := range sources {
+		if len(source.roles) > 0 {
+			fmt.Printf("roles %s from %s\n", strings.Join(source.roles, ", "), source.name)
+			return source.roles, nil
+		}
+	}
+	return nil, fmt.Errorf("no role set by flag, APP, %s or owner labels", PodInfoLabelsPath)
+}
+
+func labelRoles(labels map[string]string) []string {
+	if roles := splitRoles(labels[RolesLabel], "."); len(roles) > 0 {
+		return roles
+	}
+	return splitRoles(labels[AppLabel], ".")
+}
+
+func splitRoles(value string, separator string) []string {
+	var roles []string
+	for _, role := range strings.Split(value, separator) {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// ReadPodInfoLabels parses a downward API labels file. A missing file
+// yields no labels.
+func ReadPodInfoLabels(path string) (map[string]string, error) {
+	labels := map[string]string{}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return labels, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s: malformed line %q", path, line)
+		}
+		value, err := strconv.Unquote(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: malformed value in line %q: %v", path, line, err)
+		}
+		labels[parts[0]] = value
+	}
+	return labels, scanner.Err()
+}
@@ -0,0 +1,33 @@
+package k8s
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveRoles(t *testing.T) {
+	k := &K8S{OwnerName: "contrail-control", OwnerLabels: map[string]string{AppLabel: "contrail-control"}}
+	tests := []struct {
+		name      string
+		flag      string
+		app       string
+		podLabels map[string]string
+		want      []string
+	}{
+		{"flag", "control,dns", "contrail-control", map[string]string{RolesLabel: "named"}, []string{"control", "dns"}},
+		{"roles label over APP", "", "contrail-control", map[string]string{AppLabel: "contrail-control", RolesLabel: "control.dns.named"}, []string{"control", "dns", "named"}},
+		{"APP", "", "contrail-control", map[string]string{AppLabel: "contrail-control"}, []string{"contrail-control"}},
+		{"app label", "", "", map[string]string{AppLabel: "contrail-dns"}, []string{"contrail-dns"}},
+		{"owner labels", "", "", nil, []string{"contrail-control"}},
+	}
+	for _, test := range tests {
+		got, err := k.resolveRoles(test.flag, test.app, test.podLabels)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// CollectorService is the Contrail analytics collector service the
+	// other roles report to.
+	CollectorService = "contrail-collector"
+	CollectorPort    = 8086
+	// DNSService is the contrail-dns service vrouter agents query.
+	DNSService = "contrail-dns"
+	DNSPort    = 53
+)
+
+// ServiceAddress returns the cluster IPs of a service in the namespace,
+// one per family on dual-stack services, with its port named portName, or
+// defaultPort if the service has no such port. found is false if the
+// service does not exist.
+func (k *K8S) ServiceAddress(name string, portName string, defaultPort int32) (Endpoint, bool, error) {
+	ctx := context.Background()
+	service, err := k.ClientSet.CoreV1().Services(k.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return Endpoint{}, false, nil
+		}
+		return Endpoint{}, false, err
+	}
+	endpoint, err := ParseEndpoint(servicePort(service.Spec.Ports, portName, defaultPort), clusterIPs(service)...)
+	if err != nil {
+		return Endpoint{}, false, fmt.Errorf("service %s: %v", name, err)
+	}
+	return endpoint, !endpoint.IsZero(), nil
+}
+
+// clusterIPs returns the cluster IPs of a service, primary family first.
+// API servers before 1.20 only set ClusterIP. Headless services have none.
+func clusterIPs(service *corev1.Service) []string {
+	ips := service.Spec.ClusterIPs
+	if len(ips) == 0 {
+		ips = []string{service.Spec.ClusterIP}
+	}
+	var valid []string
+	for _, ip := range ips {
+		if ip != corev1.ClusterIPNone {
+			valid = append(valid, ip)
+		}
+	}
+	return valid
+}
+
+func servicePort(ports []corev1.ServicePort, portName string, defaultPort int32) int32 {
+	for _, port := range ports {
+		if port.Name == portName {
+			return port.Port
+		}
+	}
+	return defaultPort
+}
+
+// EndpointAddresses returns the ready endpoints of a service in the
+// namespace, using the endpoint port named portName or defaultPort. They
+// are sorted so the rendered config is stable. A missing service yields no
+// endpoints.
+func (k *K8S) EndpointAddresses(name string, portName string, defaultPort int32) ([]Endpoint, error) {
+	ctx := context.Background()
+	endpoints, err := k.ClientSet.CoreV1().Endpoints(k.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var addresses []Endpoint
+	for _, subset := range endpoints.Subsets {
+		port := defaultPort
+		for _, endpointPort := range subset.Ports {
+			if endpointPort.Name == portName {
+				port = endpointPort.Port
+			}
+		}
+		for _, address := range subset.Addresses {
+			endpoint, err := ParseEndpoint(port, address.IP)
+			if err != nil {
+				return nil, fmt.Errorf("endpoints %s: %v", name, err)
+			}
+			addresses = append(addresses, endpoint)
+		}
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i].String() < addresses[j].String()
+	})
+	return addresses, nil
+}
+
+// serviceEndpoints returns the space separated ready endpoints of a
+// service, or an empty string if the service does not exist. Until an
+// endpoint is ready the service address is used.
+func (k *K8S) serviceEndpoints(name string, portName string, defaultPort int32) (string, error) {
+	addresses, err := k.EndpointAddresses(name, portName, defaultPort)
+	if err != nil {
+		return "", err
+	}
+	if len(addresses) == 0 {
+		endpoint, found, err := k.ServiceAddress(name, portName, defaultPort)
+		if err != nil || !found {
+			return "", err
+		}
+		addresses = []Endpoint{endpoint}
+	}
+	return JoinEndpoints(addresses, " "), nil
+}
+
+// Collectors returns the collector endpoints for the collectors= setting,
+// or an empty string if there is no collector service.
+func (k *K8S) Collectors() (string, error) {
+	collectors, err := k.serviceEndpoints(CollectorService, "collector", CollectorPort)
+	if err != nil {
+		return "", err
+	}
+	k.AddFact("collectors", collectors)
+	return collectors, nil
+}
+
+// DNSServers returns the contrail-dns endpoints for the vrouter's [DNS]
+// servers= setting, or an empty string if there is no DNS service.
+func (k *K8S) DNSServers() (string, error) {
+	servers, err := k.serviceEndpoints(DNSService, "dns", DNSPort)
+	if err != nil {
+		return "", err
+	}
+	k.AddFact("dnsServers", servers)
+	return servers, nil
+}
+
+// CollectorsSetting returns the collectors= line for a [DEFAULT] section,
+// or nothing while there is no collector.
+func CollectorsSetting(collectors string) string {
+	if collectors == "" {
+		return ""
+	}
+	return "collectors=" + collectors + "\n"
+}
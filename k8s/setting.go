@@ -0,0 +1,49 @@
+package k8s
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GetNode returns the node of Hostname. It is fetched once per host.
+func (k *K8S) GetNode() (*corev1.Node, error) {
+	if k.Node != nil && k.Node.Name == k.Hostname {
+		return k.Node, nil
+	}
+	ctx := context.Background()
+	node, err := k.ClientSet.CoreV1().Nodes().Get(ctx, k.Hostname, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	k.Node = node
+	return node, nil
+}
+
+// Setting looks up a per-node setting in, in order, the annotations and
+// labels of the node and the annotations and labels of the owner. Labels
+// cannot hold every value, e.g. MAC addresses, so annotations come first.
+// It returns the value and where it was found, or empty strings if the
+// setting is not set anywhere.
+func (k *K8S) Setting(key string) (string, string, error) {
+	node, err := k.GetNode()
+	if err != nil {
+		return "", "", err
+	}
+	sources := []struct {
+		name   string
+		values map[string]string
+	}{
+		{"node " + node.Name + " annotation", node.Annotations},
+		{"node " + node.Name + " label", node.Labels},
+		{"owner " + k.OwnerName + " annotation", k.OwnerAnnotations},
+		{"owner " + k.OwnerName + " label", k.OwnerLabels},
+	}
+	for _, source := range sources {
+		if value := source.values[key]; value != "" {
+			return value, source.name + " " + key, nil
+		}
+	}
+	return "", "", nil
+}
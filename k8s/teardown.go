@@ -0,0 +1,258 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+// Teardown removes what init produced for this host: the given config
+// keys, the host's keys and certificates, leftover CSRs and the given
+// labels on the host's pods. Every step is idempotent and reports what it
+// did.
+func (k *K8S) Teardown(configNames []string, podLabels []string) error {
+	if err := k.teardownConfig(configNames); err != nil {
+		return err
+	}
+	if err := k.teardownSecret(configNames); err != nil {
+		return err
+	}
+	if err := k.teardownCSRs(); err != nil {
+		return err
+	}
+	return k.teardownPodLabels(podLabels)
+}
+
+func (k *K8S) teardownConfig(configNames []string) error {
+	ctx := context.Background()
+	if k.Output == OutputImmutable {
+		selector := labels.SelectorFromSet(labels.Set{
+			OwnerLabel: k.OwnerName,
+			HostLabel:  k.Hostname,
+		})
+		configMapList, err := k.ClientSet.CoreV1().ConfigMaps(k.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return err
+		}
+		for _, configMap := range configMapList.Items {
+			if configMap.Name == k.ActiveConfigMapName() {
+				continue
+			}
+			if err := k.deleteConfigMap(configMap.Name); err != nil {
+				return err
+			}
+		}
+		if k.Naming == NamingPerNode {
+			return k.deleteConfigMap(k.ActiveConfigMapName())
+		}
+		return k.removeConfigMapKeys(k.ActiveConfigMapName(), []string{k.Hostname})
+	}
+	if k.Naming == NamingPerNode {
+		return k.deleteConfigMap(k.ConfigMapName())
+	}
+	return k.removeConfigMapKeys(k.ConfigMapName(), configNames)
+}
+
+// teardownSecret removes this host's key and certificate and any config
+// files that were written to the Secret as they hold secrets.
+func (k *K8S) teardownSecret(configNames []string) error {
+	ctx := context.Background()
+	name := k.SecretName()
+	if k.Naming == NamingPerNode {
+		err := k.ClientSet.CoreV1().Secrets(k.Namespace).Delete(ctx, name, metav1.DeleteOptions{DryRun: k.dryRun()})
+		return k.reportDelete("secret "+name, err)
+	}
+	keys := []string{k.KeyName(), k.PemName()}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := k.ClientSet.CoreV1().Secrets(k.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return k.reportDelete("secret "+name, err)
+		}
+		var removed []string
+		for _, configName := range configNames {
+			if _, ok := secret.Data[configName]; ok {
+				delete(secret.Data, configName)
+				removed = append(removed, configName)
+			}
+		}
+		for _, key := range keys {
+			if _, ok := secret.Data[key]; ok {
+				delete(secret.Data, key)
+				removed = append(removed, key)
+			} else {
+				fmt.Printf("%s/%s: already absent\n", name, key)
+			}
+		}
+		if len(removed) == 0 {
+			return nil
+		}
+		if err := DropProvenance(&secret.ObjectMeta, removed); err != nil {
+			return err
+		}
+		if _, err := k.ClientSet.CoreV1().Secrets(k.Namespace).Update(ctx, secret, metav1.UpdateOptions{DryRun: k.dryRun()}); err != nil {
+			return err
+		}
+		for _, key := range removed {
+			fmt.Printf("%s/%s: removed\n", name, key)
+		}
+		return nil
+	})
+}
+
+// teardownCSRs deletes the CSR of this host as well as any labeled CSRs
+// left behind by interrupted runs.
+func (k *K8S) teardownCSRs() error {
+	ctx := context.Background()
+	names := map[string]bool{k.CSRName(): true}
+	selector := labels.SelectorFromSet(labels.Set{
+		OwnerLabel: k.OwnerName,
+		HostLabel:  k.Hostname,
+	})
+	csrList, err := k.ClientSet.CertificatesV1beta1().CertificateSigningRequests().List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+	for _, csr := range csrList.Items {
+		names[csr.Name] = true
+	}
+	for name := range names {
+		err := k.ClientSet.CertificatesV1beta1().CertificateSigningRequests().Delete(ctx, name, metav1.DeleteOptions{DryRun: k.dryRun()})
+		if err := k.reportDelete("csr "+name, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// teardownPodLabels strips podLabels from this pod and the pods of the same
+// controllers on this host, see podOwnerUIDs. Pods of other workloads are
+// never touched.
+func (k *K8S) teardownPodLabels(podLabels []string) error {
+	if len(podLabels) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	podList, err := k.ClientSet.CoreV1().Pods(k.Namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", k.Hostname).String(),
+	})
+	if err != nil {
+		return err
+	}
+	owners := k.podOwnerUIDs()
+	for _, pod := range podList.Items {
+		if pod.Name != k.PodName && !ownedBy(pod.OwnerReferences, owners) {
+			continue
+		}
+		podName := pod.Name
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			pod, err := k.ClientSet.CoreV1().Pods(k.Namespace).Get(ctx, podName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			var removed []string
+			for _, label := range podLabels {
+				if _, ok := pod.Labels[label]; ok {
+					delete(pod.Labels, label)
+					removed = append(removed, label)
+				}
+			}
+			if len(removed) == 0 {
+				fmt.Printf("pod %s: labels %s already absent\n", podName, strings.Join(podLabels, ", "))
+				return nil
+			}
+			if _, err := k.ClientSet.CoreV1().Pods(k.Namespace).Update(ctx, pod, metav1.UpdateOptions{DryRun: k.dryRun()}); err != nil {
+				return err
+			}
+			fmt.Printf("pod %s: removed labels %s\n", podName, strings.Join(removed, ", "))
+			return nil
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// podOwnerUIDs returns the UIDs of the controllers whose pods on this host
+// are torn down: the owner workload, which controls DaemonSet and
+// StatefulSet pods, and the controller of this pod, e.g. the ReplicaSet of
+// a Deployment.
+func (k *K8S) podOwnerUIDs() map[types.UID]bool {
+	uids := map[types.UID]bool{}
+	if k.OwnerReference != nil {
+		uids[k.OwnerReference.UID] = true
+	}
+	if k.Pod != nil {
+		if ref := metav1.GetControllerOf(k.Pod); ref != nil {
+			uids[ref.UID] = true
+		}
+	}
+	return uids
+}
+
+func ownedBy(refs []metav1.OwnerReference, uids map[types.UID]bool) bool {
+	for _, ref := range refs {
+		if ref.Controller != nil && *ref.Controller && uids[ref.UID] {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *K8S) deleteConfigMap(name string) error {
+	ctx := context.Background()
+	err := k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Delete(ctx, name, metav1.DeleteOptions{DryRun: k.dryRun()})
+	return k.reportDelete("configmap "+name, err)
+}
+
+func (k *K8S) removeConfigMapKeys(name string, keys []string) error {
+	ctx := context.Background()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return k.reportDelete("configmap "+name, err)
+		}
+		var removed []string
+		for _, key := range keys {
+			if _, ok := configMap.Data[key]; ok {
+				delete(configMap.Data, key)
+				removed = append(removed, key)
+			} else {
+				fmt.Printf("%s/%s: already absent\n", name, key)
+			}
+		}
+		if len(removed) == 0 {
+			return nil
+		}
+		if err := DropProvenance(&configMap.ObjectMeta, removed); err != nil {
+			return err
+		}
+		if _, err := k.ClientSet.CoreV1().ConfigMaps(k.Namespace).Update(ctx, configMap, metav1.UpdateOptions{DryRun: k.dryRun()}); err != nil {
+			return err
+		}
+		for _, key := range removed {
+			fmt.Printf("%s/%s: removed\n", name, key)
+		}
+		return nil
+	})
+}
+
+// reportDelete treats a missing object as already torn down.
+func (k *K8S) reportDelete(object string, err error) error {
+	switch {
+	case err == nil:
+		fmt.Printf("%s: deleted\n", object)
+	case errors.IsNotFound(err):
+		fmt.Printf("%s: already absent\n", object)
+	default:
+		return err
+	}
+	return nil
+}
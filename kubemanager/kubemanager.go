@@ -0,0 +1,146 @@
+package kubemanager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/michaelhenkel/contrail-init/k8s"
+	"github.com/michaelhenkel/contrail-init/registry"
+)
+
+const (
+	tokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	defaultClusterName     = "k8s"
+	defaultPodSubnets      = "10.32.0.0/12"
+	defaultServiceSubnets  = "10.96.0.0/12"
+	defaultIPFabricSubnets = "10.64.0.0/12"
+)
+
+type Kubemanager struct {
+	K8S *k8s.K8S
+
+	clusterName     string
+	podSubnets      string
+	serviceSubnets  string
+	ipFabricSubnets string
+}
+
+func init() {
+	registry.Register(registry.Role{
+		Name:        "contrail-kubemanager",
+		Aliases:     []string{"kubemanager"},
+		Description: "Contrail kube-manager: Kubernetes API, subnets and config DB endpoints",
+		New: func(k *k8s.K8S) registry.ContrailInit {
+			return &Kubemanager{K8S: k}
+		},
+	})
+}
+
+// clusterConfiguration is the part of the kubeadm ClusterConfiguration
+// kube-manager needs.
+type clusterConfiguration struct {
+	ClusterName string `json:"clusterName"`
+	Networking  struct {
+		PodSubnet     string `json:"podSubnet"`
+		ServiceSubnet string `json:"serviceSubnet"`
+	} `json:"networking"`
+}
+
+// Discover takes the cluster name and subnets from the CLUSTER_NAME,
+// POD_SUBNETS, SERVICE_SUBNETS and IP_FABRIC_SUBNETS environment
+// variables, then from the kubeadm-config ConfigMap and finally falls
+// back to the Contrail defaults.
+func (m *Kubemanager) Discover() error {
+	var kubeadm clusterConfiguration
+	ctx := context.Background()
+	kubeadmConfig, err := m.K8S.ClientSet.CoreV1().ConfigMaps("kube-system").Get(ctx, "kubeadm-config", metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if err := yaml.Unmarshal([]byte(kubeadmConfig.Data["ClusterConfiguration"]), &kubeadm); err != nil {
+			return fmt.Errorf("cannot parse kubeadm-config: %v", err)
+		}
+	}
+
+	m.clusterName = firstSet(os.Getenv("CLUSTER_NAME"), kubeadm.ClusterName, defaultClusterName)
+	m.podSubnets = firstSet(os.Getenv("POD_SUBNETS"), kubeadm.Networking.PodSubnet, defaultPodSubnets)
+	m.serviceSubnets = firstSet(os.Getenv("SERVICE_SUBNETS"), kubeadm.Networking.ServiceSubnet, defaultServiceSubnets)
+	m.ipFabricSubnets = firstSet(os.Getenv("IP_FABRIC_SUBNETS"), defaultIPFabricSubnets)
+
+	m.K8S.AddFact("clusterName", m.clusterName)
+	m.K8S.AddFact("podSubnets", m.podSubnets)
+	m.K8S.AddFact("serviceSubnets", m.serviceSubnets)
+	m.K8S.AddFact("ipFabricSubnets", m.ipFabricSubnets)
+	return nil
+}
+
+func firstSet(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (m *Kubemanager) Validate() error {
+	if m.K8S.APIServer.IsZero() {
+		return fmt.Errorf("kubernetes service has no cluster IP")
+	}
+	for name, subnets := range map[string]string{
+		"pod":       m.podSubnets,
+		"service":   m.serviceSubnets,
+		"ip fabric": m.ipFabricSubnets,
+	} {
+		for _, subnet := range strings.Split(subnets, ",") {
+			if _, _, err := net.ParseCIDR(strings.TrimSpace(subnet)); err != nil {
+				return fmt.Errorf("invalid %s subnet %q: %v", name, subnet, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Kubemanager) Render() (*k8s.Rendered, error) {
+	kubemanagerConfig := `[DEFAULTS]
+host_ip=` + m.K8S.PodIP + `
+orchestrator=kubernetes
+log_level=SYS_DEBUG
+log_file=/var/log/contrail/contrail-kube-manager.log
+[KUBERNETES]
+kubernetes_api_server=` + m.K8S.APIServer.Host() + `
+kubernetes_api_secure_port=` + strconv.Itoa(int(m.K8S.APIServer.Port)) + `
+kubernetes_token_file=` + tokenFile + `
+cluster_name=` + m.clusterName + `
+pod_subnets=` + m.podSubnets + `
+service_subnets=` + m.serviceSubnets + `
+ip_fabric_subnets=` + m.ipFabricSubnets + `
+[CONFIGDB]
+config_db_use_k8s=1
+config_db_use_ssl=1
+config_db_server_list=` + m.K8S.APIServer.String() + `
+config_db_ca_certs=/etc/contrailkeys/` + m.K8S.PemName() + `
+[SANDESH]`
+	return &k8s.Rendered{
+		Config:      map[string]string{m.configName(): kubemanagerConfig},
+		Certificate: true,
+	}, nil
+}
+
+func (m *Kubemanager) configName() string {
+	return "contrail-kubemanager-" + m.K8S.Hostname + ".conf"
+}
+
+func (m *Kubemanager) Teardown() error {
+	return m.K8S.Teardown([]string{m.configName()}, nil)
+}
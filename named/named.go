@@ -0,0 +1,98 @@
+package named
+
+import (
+	"github.com/michaelhenkel/contrail-init/k8s"
+	"github.com/michaelhenkel/contrail-init/registry"
+)
+
+// rndcPort is where named listens for rndc commands from contrail-dns.
+const rndcPort = "8094"
+
+type Named struct {
+	K8S *k8s.K8S
+}
+
+func init() {
+	registry.Register(registry.Role{
+		Name:        "contrail-named",
+		Aliases:     []string{"named"},
+		Description: "Contrail named: named and rndc config with the shared rndc secret",
+		New: func(k *k8s.K8S) registry.ContrailInit {
+			return &Named{K8S: k}
+		},
+	})
+}
+
+// Discover reads the shared rndc secret or generates it if no other host
+// has yet, Apply then creates it. named includes it from the mounted
+// Secret, so only contrail-dns renders the value.
+func (n *Named) Discover() error {
+	_, err := n.K8S.RndcSecret()
+	return err
+}
+
+func (n *Named) Validate() error {
+	return nil
+}
+
+func (n *Named) Render() (*k8s.Rendered, error) {
+	// The key is included from the mounted Secret so it never lands in the
+	// ConfigMap.
+	rndcKey := `include "` + k8s.RndcDir + `/` + k8s.RndcKey + `";
+`
+	namedConfig := `options {
+    directory "/etc/contrail/dns";
+    managed-keys-directory "/etc/contrail/dns";
+    empty-zones-enable no;
+    pid-file "/etc/contrail/dns/contrail-named.pid";
+    session-keyfile "/etc/contrail/dns/session.key";
+    listen-on port 53 { any; };
+    allow-query { any; };
+    allow-recursion { any; };
+    allow-query-cache { any; };
+    max-cache-size 32M;
+};
+` + rndcKey + `controls {
+    inet 127.0.0.1 port ` + rndcPort + `
+    allow { 127.0.0.1; } keys { "` + k8s.RndcKeyName + `"; };
+};
+logging {
+    channel debug_log {
+        file "/var/log/contrail/contrail-named.log" versions 3 size 5m;
+        severity debug;
+        print-time yes;
+        print-severity yes;
+        print-category yes;
+    };
+    category default {
+        debug_log;
+    };
+    category queries {
+        debug_log;
+    };
+};`
+	rndcConfig := rndcKey + `options {
+    default-key "` + k8s.RndcKeyName + `";
+    default-server 127.0.0.1;
+    default-port ` + rndcPort + `;
+};`
+	return &k8s.Rendered{
+		Config: map[string]string{
+			n.namedConfigName(): namedConfig,
+			n.rndcConfigName():  rndcConfig,
+		},
+		SharedSecrets: []string{k8s.RndcSecretName},
+	}, nil
+}
+
+func (n *Named) namedConfigName() string {
+	return "contrail-named-" + n.K8S.Hostname + ".conf"
+}
+
+func (n *Named) rndcConfigName() string {
+	return "contrail-rndc-" + n.K8S.Hostname + ".conf"
+}
+
+func (n *Named) Teardown() error {
+	return n.K8S.Teardown([]string{n.namedConfigName(), n.rndcConfigName()}, nil)
+}
@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/michaelhenkel/contrail-init/k8s"
+)
+
+// ContrailInit is the Contrail Init interface. A run goes through the
+// phases Discover, Validate, Render, Apply and Verify; the last two are
+// the same for every role and implemented by k8s.K8S.
+type ContrailInit interface {
+	// Discover gathers the facts the config depends on and records them
+	// with K8S.AddFact.
+	Discover() error
+	// Validate checks the discovered facts.
+	Validate() error
+	// Render returns the config without side effects.
+	Render() (*k8s.Rendered, error)
+	// Teardown removes what Apply produced for this host.
+	Teardown() error
+}
+
+// Role describes a Contrail component contrail-init can initialize. Role
+// packages register themselves from an init function, so importing a
+// package is enough to make its role available.
+type Role struct {
+	Name        string
+	Aliases     []string
+	Description string
+	New         func(k *k8s.K8S) ContrailInit
+}
+
+var (
+	roles   = map[string]*Role{}
+	aliases = map[string]*Role{}
+)
+
+// Register adds a role. It panics if the name or an alias is taken.
+func Register(role Role) {
+	r := &role
+	for _, name := range append([]string{role.Name}, role.Aliases...) {
+		if _, ok := aliases[name]; ok {
+			panic(fmt.Sprintf("role %s registered twice", name))
+		}
+		aliases[name] = r
+	}
+	roles[role.Name] = r
+}
+
+// Lookup finds a role by name or alias.
+func Lookup(name string) (*Role, bool) {
+	role, ok := aliases[name]
+	return role, ok
+}
+
+// Roles returns all registered roles sorted by name.
+func Roles() []*Role {
+	var list []*Role
+	for _, role := range roles {
+		list = append(list, role)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
@@ -0,0 +1,148 @@
+package vrouter
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sysClassNet is where procSnapshot reads the link hierarchy from.
+var sysClassNet = "/sys/class/net"
+
+// bondModes are the names of the IFLA_BOND_MODE values.
+var bondModes = []string{"balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"}
+
+func (s *Snapshot) LinkByIndex(index int) (Link, bool) {
+	for _, link := range s.Links {
+		if link.Index == index {
+			return link, true
+		}
+	}
+	return Link{}, false
+}
+
+// Slaves returns the links enslaved to a bond, team or bridge.
+func (s *Snapshot) Slaves(master Link) []Link {
+	var slaves []Link
+	for _, link := range s.Links {
+		if link.MasterIndex == master.Index {
+			slaves = append(slaves, link)
+		}
+	}
+	return slaves
+}
+
+// topLevel picks the link vhost0 sits on from links sharing its MAC
+// address. Bond and team slaves share the MAC of their master and vlans
+// the MAC of their parent, so slaves and links other candidates are
+// stacked on are dropped. Of several unrelated links the first by name is
+// taken.
+func (s *Snapshot) topLevel(candidates []Link) string {
+	below := map[int]bool{}
+	for _, link := range candidates {
+		if link.MasterIndex != 0 {
+			below[link.Index] = true
+		}
+		if link.ParentIndex != 0 {
+			below[link.ParentIndex] = true
+		}
+	}
+	var names []string
+	for _, link := range candidates {
+		if !below[link.Index] {
+			names = append(names, link.Name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	if len(names) > 1 {
+		fmt.Printf("links %s share the vhost0 MAC address, using %s\n", strings.Join(names, ", "), names[0])
+	}
+	return names[0]
+}
+
+// sysfsHierarchy fills in what net.Interfaces does not report: the kind,
+// master and parent of each link, bond modes and vlan IDs. Missing files
+// are skipped.
+func sysfsHierarchy(links []Link) {
+	indexes := map[string]int{}
+	for _, link := range links {
+		indexes[link.Name] = link.Index
+	}
+	vlans := procVLANs()
+	for i := range links {
+		link := &links[i]
+		dir := filepath.Join(sysClassNet, link.Name)
+		if master, err := os.Readlink(filepath.Join(dir, "master")); err == nil {
+			link.MasterIndex = indexes[filepath.Base(master)]
+		}
+		if uevent, err := ioutil.ReadFile(filepath.Join(dir, "uevent")); err == nil {
+			for _, line := range strings.Split(string(uevent), "\n") {
+				if strings.HasPrefix(line, "DEVTYPE=") {
+					link.Kind = strings.TrimPrefix(line, "DEVTYPE=")
+				}
+			}
+		}
+		if mode, err := ioutil.ReadFile(filepath.Join(dir, "bonding", "mode")); err == nil {
+			if fields := strings.Fields(string(mode)); len(fields) > 0 {
+				link.Kind = "bond"
+				link.BondMode = fields[0]
+			}
+		}
+		if vlan, ok := vlans[link.Name]; ok {
+			link.Kind = "vlan"
+			link.VLANID = vlan.id
+			link.ParentIndex = indexes[vlan.parent]
+		}
+	}
+}
+
+// sysfsPCIAddresses sets the PCI address of links backed by a PCI device.
+func sysfsPCIAddresses(links []Link) {
+	for i := range links {
+		device := filepath.Join(sysClassNet, links[i].Name, "device")
+		subsystem, err := os.Readlink(filepath.Join(device, "subsystem"))
+		if err != nil || filepath.Base(subsystem) != "pci" {
+			continue
+		}
+		if address, err := os.Readlink(device); err == nil {
+			links[i].PCIAddress = filepath.Base(address)
+		}
+	}
+}
+
+type procVLAN struct {
+	id     int
+	parent string
+}
+
+// procVLANs parses /proc/net/vlan/config: two header lines, then
+// "name | vlan id | parent" per vlan.
+func procVLANs() map[string]procVLAN {
+	vlans := map[string]procVLAN{}
+	file, err := os.Open("/proc/net/vlan/config")
+	if err != nil {
+		return vlans
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 0; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), "|")
+		if line < 2 || len(fields) != 3 {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err != nil {
+			continue
+		}
+		vlans[strings.TrimSpace(fields[0])] = procVLAN{id: id, parent: strings.TrimSpace(fields[2])}
+	}
+	return vlans
+}
@@ -0,0 +1,54 @@
+package vrouter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// GetIPv6Routes parses /proc/net/ipv6_route. It has no header; each line
+// holds destination, prefix length, source, prefix length, next hop,
+// metric, reference count, use count, flags and interface, the numbers in
+// hex.
+func GetIPv6Routes(file io.Reader) ([]Route, error) {
+	routes := []Route{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("wrong number of fields (expected 10, got %d): %s", len(fields), line)
+		}
+		destination, err := parseIP(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		gateway, err := parseIP(fields[4])
+		if err != nil {
+			return nil, err
+		}
+		var numbers [5]uint64
+		for i, field := range []string{fields[1], fields[5], fields[6], fields[7], fields[8]} {
+			if numbers[i], err = strconv.ParseUint(field, 16, 32); err != nil {
+				return nil, fmt.Errorf("invalid number in %s: %v", line, err)
+			}
+		}
+		routes = append(routes, Route{
+			Interface:   fields[9],
+			Destination: destination,
+			Gateway:     gateway,
+			Mask:        net.CIDRMask(int(numbers[0]), 8*net.IPv6len),
+			Metric:      int(numbers[1]),
+			RefCnt:      int(numbers[2]),
+			Use:         int(numbers[3]),
+			Flags:       uint32(numbers[4]),
+		})
+	}
+	return routes, scanner.Err()
+}
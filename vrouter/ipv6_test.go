@@ -0,0 +1,40 @@
+package vrouter
+
+import (
+	"strings"
+	"testing"
+)
+
+const testIPv6Routes = `fd000020000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth1
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fd000020000000000000000000000001 00000400 00000002 00000000 00000003     eth1
+`
+
+func TestGetIPv6Routes(t *testing.T) {
+	routes, err := GetIPv6Routes(strings.NewReader(testIPv6Routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("got %d routes, want 2", len(routes))
+	}
+	want := []Route{
+		testRoute("eth1", "fd00:20::/64", "::", 256, RouteFlagUp),
+		testRoute("eth1", "::/0", "fd00:20::1", 1024, RouteFlagUp|RouteFlagGateway),
+	}
+	want[0].RefCnt, want[1].RefCnt = 1, 2
+	for i, route := range routes {
+		// The prefix length, metric and flags are hex.
+		if route.Interface != want[i].Interface ||
+			!route.Destination.Equal(want[i].Destination) || !route.Gateway.Equal(want[i].Gateway) ||
+			route.Mask.String() != want[i].Mask.String() || route.Metric != want[i].Metric ||
+			route.RefCnt != want[i].RefCnt || route.Flags != want[i].Flags {
+			t.Errorf("route %d: got %+v, want %+v", i, route, want[i])
+		}
+	}
+}
+
+func TestGetIPv6RoutesShortLine(t *testing.T) {
+	if _, err := GetIPv6Routes(strings.NewReader("00000000000000000000000000000000 00 eth1\n")); err == nil {
+		t.Error("got no error for a line with 3 fields")
+	}
+}
@@ -0,0 +1,329 @@
+//go:build linux
+// +build linux
+
+package vrouter
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"syscall"
+	"unsafe"
+)
+
+// Nested attributes of IFLA_LINKINFO and its IFLA_INFO_DATA from
+// linux/if_link.h.
+const (
+	iflaInfoKind = 1
+	iflaInfoData = 2
+	iflaVLANID   = 1
+	iflaBondMode = 1
+)
+
+// iflaLinkNetnsid is IFLA_LINK_NETNSID, set when IFLA_LINK is in another
+// network namespace, as for the host end of a pod veth.
+const iflaLinkNetnsid = 37
+
+// sizeofRtNexthop is the size of struct rtnexthop in an RTA_MULTIPATH
+// attribute.
+const sizeofRtNexthop = 8
+
+// netlinkSnapshot dumps links, addresses and the routes of all tables
+// through rtnetlink.
+func netlinkSnapshot() (*Snapshot, error) {
+	snapshot := &Snapshot{Source: "netlink"}
+	links, err := netlinkLinks()
+	if err != nil {
+		return nil, err
+	}
+	if err := netlinkAddresses(links); err != nil {
+		return nil, err
+	}
+	names := map[int]string{}
+	for _, link := range links {
+		names[link.Index] = link.Name
+		snapshot.Links = append(snapshot.Links, *link)
+	}
+	sort.Slice(snapshot.Links, func(i, j int) bool {
+		return snapshot.Links[i].Index < snapshot.Links[j].Index
+	})
+	snapshot.Routes, err = netlinkRoutes(names)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// netlinkDump sends a dump request and returns the messages of type
+// msgType.
+func netlinkDump(request int, msgType uint16) ([]syscall.NetlinkMessage, error) {
+	rib, err := syscall.NetlinkRIB(request, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("netlink dump %d: %v", request, err)
+	}
+	messages, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+	var matching []syscall.NetlinkMessage
+	for _, m := range messages {
+		switch m.Header.Type {
+		case syscall.NLMSG_DONE:
+			return matching, nil
+		case syscall.NLMSG_ERROR:
+			return nil, fmt.Errorf("netlink dump %d failed", request)
+		case msgType:
+			matching = append(matching, m)
+		}
+	}
+	return matching, nil
+}
+
+// netlinkLinks returns the links by index.
+func netlinkLinks() (map[int]*Link, error) {
+	messages, err := netlinkDump(syscall.RTM_GETLINK, syscall.RTM_NEWLINK)
+	if err != nil {
+		return nil, err
+	}
+	links := map[int]*Link{}
+	for i := range messages {
+		m := &messages[i]
+		if len(m.Data) < syscall.SizeofIfInfomsg {
+			continue
+		}
+		info := (*syscall.IfInfomsg)(unsafe.Pointer(&m.Data[0]))
+		link := &Link{
+			Index: int(info.Index),
+			Up:    info.Flags&syscall.IFF_UP != 0,
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			return nil, err
+		}
+		parent, otherNetns := 0, false
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.IFLA_IFNAME:
+				link.Name = cString(attr.Value)
+			case syscall.IFLA_ADDRESS:
+				link.MAC = net.HardwareAddr(attr.Value).String()
+			case syscall.IFLA_MTU:
+				link.MTU = int(nativeUint32(attr.Value))
+			case syscall.IFLA_MASTER:
+				link.MasterIndex = int(nativeUint32(attr.Value))
+			case syscall.IFLA_LINK:
+				parent = int(nativeUint32(attr.Value))
+			case iflaLinkNetnsid:
+				otherNetns = true
+			case syscall.IFLA_LINKINFO:
+				var data []byte
+				for _, info := range parseAttrs(attr.Value) {
+					switch info.Attr.Type {
+					case iflaInfoKind:
+						link.Kind = cString(info.Value)
+					case iflaInfoData:
+						data = info.Value
+					}
+				}
+				linkInfoData(link, data)
+			}
+		}
+		if parent != link.Index && !otherNetns {
+			link.ParentIndex = parent
+		}
+		links[link.Index] = link
+	}
+	return links, nil
+}
+
+// linkInfoData reads the vlan ID or bond mode from IFLA_INFO_DATA.
+func linkInfoData(link *Link, data []byte) {
+	for _, attr := range parseAttrs(data) {
+		switch {
+		case link.Kind == "vlan" && attr.Attr.Type == iflaVLANID && len(attr.Value) >= 2:
+			link.VLANID = int(*(*uint16)(unsafe.Pointer(&attr.Value[0])))
+		case link.Kind == "bond" && attr.Attr.Type == iflaBondMode && len(attr.Value) >= 1:
+			if mode := int(attr.Value[0]); mode < len(bondModes) {
+				link.BondMode = bondModes[mode]
+			}
+		}
+	}
+}
+
+func netlinkAddresses(links map[int]*Link) error {
+	messages, err := netlinkDump(syscall.RTM_GETADDR, syscall.RTM_NEWADDR)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		m := &messages[i]
+		if len(m.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+		info := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		link, ok := links[int(info.Index)]
+		if !ok {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			return err
+		}
+		address := Address{
+			PrefixLength: int(info.Prefixlen),
+			Secondary:    info.Flags&syscall.IFA_F_SECONDARY != 0,
+		}
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.IFA_LOCAL:
+				// IFA_LOCAL is the own address on point-to-point links
+				// where IFA_ADDRESS is the peer.
+				address.IP = net.IP(attr.Value)
+			case syscall.IFA_ADDRESS:
+				if address.IP == nil {
+					address.IP = net.IP(attr.Value)
+				}
+			}
+		}
+		if address.IP != nil {
+			link.Addresses = append(link.Addresses, address)
+		}
+	}
+	return nil
+}
+
+// netlinkRoutes returns the unicast and unreachable routes of all tables.
+// Multipath routes are returned as one route per nexthop.
+func netlinkRoutes(names map[int]string) ([]Route, error) {
+	messages, err := netlinkDump(syscall.RTM_GETROUTE, syscall.RTM_NEWROUTE)
+	if err != nil {
+		return nil, err
+	}
+	var routes []Route
+	for i := range messages {
+		m := &messages[i]
+		if len(m.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+		msg := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+		size := net.IPv4len
+		if msg.Family == syscall.AF_INET6 {
+			size = net.IPv6len
+		}
+		route := Route{
+			Destination: make(net.IP, size),
+			Gateway:     make(net.IP, size),
+			Mask:        net.CIDRMask(int(msg.Dst_len), 8*size),
+			Flags:       RouteFlagUp,
+			Table:       int(msg.Table),
+		}
+		switch msg.Type {
+		case syscall.RTN_UNICAST:
+		case syscall.RTN_UNREACHABLE, syscall.RTN_PROHIBIT, syscall.RTN_BLACKHOLE:
+			route.Flags |= RouteFlagReject
+		default:
+			continue
+		}
+		if int(msg.Dst_len) == 8*size {
+			route.Flags |= RouteFlagHost
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			return nil, err
+		}
+		var multipath []byte
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.RTA_DST:
+				route.Destination = net.IP(attr.Value)
+			case syscall.RTA_GATEWAY:
+				route.Gateway = net.IP(attr.Value)
+				route.Flags |= RouteFlagGateway
+			case syscall.RTA_OIF:
+				route.Interface = names[int(nativeUint32(attr.Value))]
+			case syscall.RTA_PRIORITY:
+				route.Metric = int(nativeUint32(attr.Value))
+			case syscall.RTA_TABLE:
+				route.Table = int(nativeUint32(attr.Value))
+			case syscall.RTA_MULTIPATH:
+				multipath = attr.Value
+			}
+		}
+		switch {
+		case multipath != nil:
+			routes = append(routes, nexthopRoutes(route, multipath, names)...)
+		case route.Interface == "" && route.Flags&RouteFlagReject == 0:
+			// The interface is gone since the link dump.
+		default:
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
+}
+
+// nexthopRoutes returns a copy of route for each nexthop of an
+// RTA_MULTIPATH attribute, a list of struct rtnexthop each followed by its
+// own attributes.
+func nexthopRoutes(route Route, b []byte, names map[int]string) []Route {
+	var routes []Route
+	for len(b) >= sizeofRtNexthop {
+		size := int(*(*uint16)(unsafe.Pointer(&b[0])))
+		if size < sizeofRtNexthop || size > len(b) {
+			break
+		}
+		nexthop := route
+		nexthop.Interface = names[int(nativeUint32(b[4:8]))]
+		for _, attr := range parseAttrs(b[sizeofRtNexthop:size]) {
+			if attr.Attr.Type == syscall.RTA_GATEWAY {
+				nexthop.Gateway = net.IP(attr.Value)
+				nexthop.Flags |= RouteFlagGateway
+			}
+		}
+		if nexthop.Interface != "" {
+			routes = append(routes, nexthop)
+		}
+		next := (size + syscall.RTA_ALIGNTO - 1) &^ (syscall.RTA_ALIGNTO - 1)
+		if next > len(b) {
+			break
+		}
+		b = b[next:]
+	}
+	return routes
+}
+
+// parseAttrs parses nested route attributes.
+func parseAttrs(b []byte) []syscall.NetlinkRouteAttr {
+	var attrs []syscall.NetlinkRouteAttr
+	for len(b) >= syscall.SizeofRtAttr {
+		a := (*syscall.RtAttr)(unsafe.Pointer(&b[0]))
+		if int(a.Len) < syscall.SizeofRtAttr || int(a.Len) > len(b) {
+			break
+		}
+		attrs = append(attrs, syscall.NetlinkRouteAttr{
+			Attr:  *a,
+			Value: b[syscall.SizeofRtAttr:a.Len],
+		})
+		next := (int(a.Len) + syscall.RTA_ALIGNTO - 1) &^ (syscall.RTA_ALIGNTO - 1)
+		if next > len(b) {
+			break
+		}
+		b = b[next:]
+	}
+	return attrs
+}
+
+func nativeUint32(b []byte) uint32 {
+	if len(b) < 4 {
+		return 0
+	}
+	return *(*uint32)(unsafe.Pointer(&b[0]))
+}
+
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,55 @@
+//go:build linux
+// +build linux
+
+package vrouter
+
+import (
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// testNexthop returns a struct rtnexthop for ifindex with an RTA_GATEWAY
+// attribute if gateway is set.
+func testNexthop(ifindex int, gateway string) []byte {
+	var attr []byte
+	if gateway != "" {
+		ip := net.ParseIP(gateway).To4()
+		attr = make([]byte, syscall.SizeofRtAttr+len(ip))
+		*(*syscall.RtAttr)(unsafe.Pointer(&attr[0])) = syscall.RtAttr{Len: uint16(len(attr)), Type: syscall.RTA_GATEWAY}
+		copy(attr[syscall.SizeofRtAttr:], ip)
+	}
+	b := make([]byte, sizeofRtNexthop, sizeofRtNexthop+len(attr))
+	*(*uint16)(unsafe.Pointer(&b[0])) = uint16(sizeofRtNexthop + len(attr))
+	*(*int32)(unsafe.Pointer(&b[4])) = int32(ifindex)
+	return append(b, attr...)
+}
+
+func TestNexthopRoutes(t *testing.T) {
+	names := map[int]string{2: "eth0", 3: "eth1"}
+	route := Route{
+		Destination: net.IPv4zero.To4(),
+		Gateway:     make(net.IP, net.IPv4len),
+		Mask:        net.CIDRMask(0, 32),
+		Flags:       RouteFlagUp,
+		Table:       254,
+	}
+	var multipath []byte
+	multipath = append(multipath, testNexthop(2, "10.0.0.1")...)
+	multipath = append(multipath, testNexthop(3, "10.1.0.1")...)
+	// A nexthop on an interface that is gone is dropped.
+	multipath = append(multipath, testNexthop(9, "10.9.0.1")...)
+	var got []string
+	for _, r := range nexthopRoutes(route, multipath, names) {
+		if r.Flags != RouteFlagUp|RouteFlagGateway || r.Table != 254 {
+			t.Errorf("%s: flags %d table %d", r.Interface, r.Flags, r.Table)
+		}
+		got = append(got, r.Interface+" "+r.Gateway.String())
+	}
+	want := []string{"eth0 10.0.0.1", "eth1 10.1.0.1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package vrouter
+
+import "fmt"
+
+func netlinkSnapshot() (*Snapshot, error) {
+	return nil, fmt.Errorf("netlink is only available on linux")
+}
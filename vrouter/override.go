@@ -0,0 +1,147 @@
+package vrouter
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Per-node overrides of discovered values. They are looked up with
+// K8S.Setting, so an annotation on the node wins over a label on the
+// owner, e.g.
+//
+//	kubectl annotate node worker-1 contrail-init/gateway=10.10.0.1
+const (
+	// GatewayKey is the gateway of the data network, one address per
+	// family, comma separated.
+	GatewayKey = "contrail-init/gateway"
+	// MaskKey is the IPv4 prefix length or netmask of the vhost0 address.
+	MaskKey = "contrail-init/mask"
+	// VhostIPKey is the vhost0 address, one per family, with or without
+	// prefix length. It wins over DataNetworkKey.
+	VhostIPKey = "contrail-init/vhost-ip"
+	// MTUKey is the MTU of vhost0.
+	MTUKey = "contrail-init/mtu"
+)
+
+// gatewayLabel is the owner label the IPv4 gateway used to be set with.
+const gatewayLabel = "Gateway"
+
+// logSource logs where the value of a field came from.
+func logSource(field, value, source string) {
+	fmt.Printf("%s %s from %s\n", field, value, source)
+}
+
+// overrideMask replaces the IPv4 prefix length with the MaskKey setting.
+func (v *Vrouter) overrideMask() error {
+	value, source, err := v.K8S.Setting(MaskKey)
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		if v.ip != "" {
+			logSource("mask", v.mask, "the address "+v.ip)
+		}
+		return nil
+	}
+	mask, err := parseMask(value)
+	if err != nil {
+		return fmt.Errorf("%s: %v", source, err)
+	}
+	v.mask = mask
+	logSource("mask", v.mask, source)
+	return nil
+}
+
+// parseMask returns the prefix length of a prefix length or netmask.
+func parseMask(value string) (string, error) {
+	if size, err := strconv.Atoi(value); err == nil && size >= 0 && size <= 32 {
+		return value, nil
+	}
+	if ip := net.ParseIP(value).To4(); ip != nil {
+		if size, bits := net.IPMask(ip).Size(); bits != 0 {
+			return strconv.Itoa(size), nil
+		}
+	}
+	return "", fmt.Errorf("invalid mask %s", value)
+}
+
+// gateways sets the gateway of each family vhost0 has an address in from
+// the GatewayKey setting, the Gateway owner label or the routes of the
+// interface. A missing IPv6 gateway is only fatal on IPv6-only nodes.
+func (v *Vrouter) gateways(network *Snapshot) error {
+	value, source, err := v.K8S.Setting(GatewayKey)
+	if err != nil {
+		return err
+	}
+	if gateway, ok := v.K8S.OwnerLabels[gatewayLabel]; ok && value == "" {
+		value, source = gateway, "owner "+v.K8S.OwnerName+" label "+gatewayLabel
+	}
+	var gateway, gateway6 string
+	for _, gw := range strings.Split(value, ",") {
+		gw = strings.TrimSpace(gw)
+		ip := net.ParseIP(gw)
+		switch {
+		case gw == "":
+		case ip == nil:
+			return fmt.Errorf("%s: invalid gateway %s", source, gw)
+		case ip.To4() != nil:
+			gateway = ip.String()
+		default:
+			gateway6 = ip.String()
+		}
+	}
+	if v.ip != "" {
+		if v.gateway, err = v.familyGateway(network, "gateway", gateway, source, false); err != nil {
+			return err
+		}
+	}
+	if v.ip6 != "" {
+		v.gateway6, err = v.familyGateway(network, "gateway6", gateway6, source, true)
+		switch {
+		case err == nil:
+		case v.ip == "":
+			return err
+		default:
+			// Dual-stack nodes without IPv6 gateway still get IPv4.
+			fmt.Printf("%v, configuring vhost0 without IPv6\n", err)
+			v.ip6, v.mask6, v.gateway6 = "", "", ""
+		}
+	}
+	return nil
+}
+
+// familyGateway returns the overridden gateway of one family or, if there
+// is none, the gateway of the interface.
+func (v *Vrouter) familyGateway(network *Snapshot, field, gateway, source string, ipv6 bool) (string, error) {
+	if gateway == "" {
+		discovered, err := network.Gateway(v.intf, ipv6, v.controlNode)
+		if err != nil {
+			return "", err
+		}
+		gateway, source = discovered, "the routes of "+v.intf
+	}
+	logSource(field, gateway, source)
+	return gateway, nil
+}
+
+// overrideMTU sets the MTU of vhost0 from the MTUKey setting or the MTU
+// of the interface.
+func (v *Vrouter) overrideMTU() error {
+	value, source, err := v.K8S.Setting(MTUKey)
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		v.mtu = v.link.MTU
+		logSource("mtu", strconv.Itoa(v.mtu), "interface "+v.intf)
+		return nil
+	}
+	v.mtu, err = strconv.Atoi(value)
+	if err != nil || v.mtu < 68 || v.mtu > 65535 {
+		return fmt.Errorf("%s: invalid MTU %s", source, value)
+	}
+	logSource("mtu", value, source)
+	return nil
+}
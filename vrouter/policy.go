@@ -0,0 +1,229 @@
+package vrouter
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/michaelhenkel/contrail-init/k8s"
+)
+
+// InterfacePolicyKey is the node or owner annotation, or label, selecting
+// the physical interface as <policy>=<value>:
+//
+//	name=ens3f0
+//	regex=^ens3f[0-9]$
+//	mac=aa:bb:cc:dd:ee:ff
+//	pci=0000:3b:00.0
+//	cidr=10.10.0.0/16
+//	route-to-control
+//
+// A value without policy is an interface name. Without the key the
+// interface holding the data network address is used.
+const InterfacePolicyKey = "contrail-init/interface"
+
+// SelectInterface returns the physical interface the policy selects.
+// Policies matching a bond or team slave or a vlan parent select the
+// top-level link.
+func (s *Snapshot) SelectInterface(policy string, controlNode k8s.Endpoint) (string, error) {
+	parts := strings.SplitN(policy, "=", 2)
+	kind, value := parts[0], ""
+	switch {
+	case len(parts) == 2:
+		value = parts[1]
+	case kind != "route-to-control":
+		kind, value = "name", policy
+	}
+	var candidates []Link
+	switch kind {
+	case "name":
+		if link, ok := s.LinkByName(value); ok {
+			candidates = append(candidates, link)
+		}
+	case "regex":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return "", fmt.Errorf("interface policy %s: %v", policy, err)
+		}
+		for _, link := range s.Links {
+			if link.Name != "vhost0" && re.MatchString(link.Name) {
+				candidates = append(candidates, link)
+			}
+		}
+	case "mac":
+		mac, err := net.ParseMAC(value)
+		if err != nil {
+			return "", fmt.Errorf("interface policy %s: %v", policy, err)
+		}
+		for _, link := range s.Links {
+			if link.Name != "vhost0" && link.MAC == mac.String() {
+				candidates = append(candidates, link)
+			}
+		}
+	case "pci":
+		for _, link := range s.Links {
+			if link.PCIAddress == value {
+				candidates = append(candidates, link)
+			}
+		}
+	case "cidr":
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return "", fmt.Errorf("interface policy %s: %v", policy, err)
+		}
+		for _, link := range s.Links {
+			for _, address := range link.Addresses {
+				if network.Contains(address.IP) {
+					candidates = append(candidates, link)
+					break
+				}
+			}
+		}
+	case "route-to-control":
+		if controlNode.IsZero() {
+			return "", fmt.Errorf("interface policy %s: no control node", policy)
+		}
+		route, ok := LookupRoute(s.MainRoutes(controlNode.IPs[0].To4() == nil), controlNode.IPs[0])
+		if !ok {
+			return "", fmt.Errorf("interface policy %s: no route to the control node %s", policy, controlNode.Host())
+		}
+		return s.physical(route.Interface), nil
+	default:
+		return "", fmt.Errorf("unknown interface policy %q, use name, regex, mac, pci, cidr or route-to-control", policy)
+	}
+	seen := map[int]bool{}
+	var links []Link
+	for _, candidate := range candidates {
+		link, _ := s.LinkByName(s.physical(candidate.Name))
+		if link = s.master(link); !seen[link.Index] {
+			seen[link.Index] = true
+			links = append(links, link)
+		}
+	}
+	if intf := s.topLevel(links); intf != "" {
+		return intf, nil
+	}
+	return "", fmt.Errorf("interface policy %s matches no interface", policy)
+}
+
+// master returns the bond or team a link is enslaved to, or the link.
+func (s *Snapshot) master(link Link) Link {
+	for depth := 0; link.MasterIndex != 0 && depth < 8; depth++ {
+		master, ok := s.LinkByIndex(link.MasterIndex)
+		if !ok || master.Kind == "bridge" {
+			break
+		}
+		link = master
+	}
+	return link
+}
+
+// ControlNetworkKey and DataNetworkKey select the control network address
+// rendered as control_network_ip and the data network address vhost0
+// takes, see SelectAddresses. Without them both are the pod IP, or the
+// data address is the address of the interface the interface policy
+// selected.
+const (
+	ControlNetworkKey = "contrail-init/control-network"
+	DataNetworkKey    = "contrail-init/data-network"
+)
+
+// SelectAddresses returns the first IPv4 and the first IPv6 address the
+// selector picks, leaving a family without one zero:
+//
+//	cidr=10.10.0.0/16,fd00::/64   addresses of any link within the CIDRs
+//	interface=bond0.100           the addresses of the link
+//	ip=10.10.0.5/16,fd00::5/64    the addresses given
+//
+// Secondary and IPv6 link-local addresses are skipped. The addresses of a
+// link vhost0 took over are looked up on vhost0.
+func (s *Snapshot) SelectAddresses(selector string) (Address, Address, error) {
+	parts := strings.SplitN(selector, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return Address{}, Address{}, fmt.Errorf("invalid network selector %q, use cidr=, interface= or ip=", selector)
+	}
+	var addresses []Address
+	switch parts[0] {
+	case "cidr":
+		var networks []*net.IPNet
+		for _, cidr := range strings.Split(parts[1], ",") {
+			_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				return Address{}, Address{}, fmt.Errorf("network selector %s: %v", selector, err)
+			}
+			networks = append(networks, network)
+		}
+		for _, link := range s.Links {
+			for _, address := range link.Addresses {
+				for _, network := range networks {
+					if network.Contains(address.IP) {
+						addresses = append(addresses, address)
+					}
+				}
+			}
+		}
+	case "interface":
+		link, ok := s.LinkByName(parts[1])
+		if !ok {
+			return Address{}, Address{}, fmt.Errorf("network selector %s: no such interface", selector)
+		}
+		if vhost, ok := s.LinkByName("vhost0"); ok && s.physical("vhost0") == link.Name {
+			link = vhost
+		}
+		addresses = link.Addresses
+	case "ip":
+		for _, ip := range strings.Split(parts[1], ",") {
+			address, err := s.parseAddress(strings.TrimSpace(ip))
+			if err != nil {
+				return Address{}, Address{}, fmt.Errorf("network selector %s: %v", selector, err)
+			}
+			addresses = append(addresses, address)
+		}
+	default:
+		return Address{}, Address{}, fmt.Errorf("invalid network selector %q, use cidr=, interface= or ip=", selector)
+	}
+	var ip4, ip6 Address
+	for _, address := range addresses {
+		switch {
+		case address.Secondary, address.IP.IsLinkLocalUnicast():
+		case address.IP.To4() != nil && ip4.IP == nil:
+			ip4 = address
+		case address.IP.To4() == nil && ip6.IP == nil:
+			ip6 = address
+		}
+	}
+	if ip4.IP == nil && ip6.IP == nil {
+		return Address{}, Address{}, fmt.Errorf("network selector %s matches no address", selector)
+	}
+	return ip4, ip6, nil
+}
+
+// parseAddress parses an address with or without prefix length. Without
+// one the prefix length of the link holding it is used, if any. Given
+// addresses are used even if they are secondary.
+func (s *Snapshot) parseAddress(value string) (Address, error) {
+	if ip, network, err := net.ParseCIDR(value); err == nil {
+		size, _ := network.Mask.Size()
+		return Address{IP: ip, PrefixLength: size}, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return Address{}, fmt.Errorf("invalid address %s", value)
+	}
+	if _, address, ok := s.linkAddress(ip.String()); ok {
+		return Address{IP: ip, PrefixLength: address.PrefixLength}, nil
+	}
+	return Address{IP: ip}, nil
+}
+
+// joinAddresses joins the addresses that are set for logging.
+func joinAddresses(addresses ...Address) string {
+	var ips []string
+	for _, address := range addresses {
+		if address.IP != nil {
+			ips = append(ips, address.IP.String())
+		}
+	}
+	return strings.Join(ips, ",")
+}
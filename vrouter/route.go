@@ -0,0 +1,197 @@
+package vrouter
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Route flags from linux/route.h.
+const (
+	RouteFlagUp      = 0x0001
+	RouteFlagGateway = 0x0002
+	RouteFlagHost    = 0x0004
+	RouteFlagReject  = 0x0200
+)
+
+// Route is a kernel routing table entry with the columns of
+// /proc/net/route. IPv6 and netlink routes leave RefCnt, Use, MTU, Window
+// and IRTT unset.
+type Route struct {
+	Interface   string     `json:"interface"`
+	Destination net.IP     `json:"destination"`
+	Gateway     net.IP     `json:"gateway"`
+	Flags       uint32     `json:"flags"`
+	RefCnt      int        `json:"refCnt,omitempty"`
+	Use         int        `json:"use,omitempty"`
+	Metric      int        `json:"metric"`
+	Mask        net.IPMask `json:"-"`
+	MTU         int        `json:"mtu,omitempty"`
+	Window      int        `json:"window,omitempty"`
+	IRTT        int        `json:"irtt,omitempty"`
+	// Table is the routing table, see mainTable.
+	Table int `json:"table"`
+}
+
+// MarshalJSON writes the mask in address notation, e.g. 255.255.255.0.
+func (r Route) MarshalJSON() ([]byte, error) {
+	type route Route
+	return json.Marshal(struct {
+		route
+		Mask string `json:"mask"`
+	}{route(r), net.IP(r.Mask).String()})
+}
+
+// UnmarshalJSON reads the mask in address notation or as prefix length.
+// Without a mask a route to the unspecified address is a default route and
+// any other route a host route, as in hand-written fixtures.
+func (r *Route) UnmarshalJSON(b []byte) error {
+	type route Route
+	aux := struct {
+		*route
+		Mask string `json:"mask"`
+	}{route: (*route)(r)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	if r.Destination == nil {
+		return fmt.Errorf("route without destination")
+	}
+	bits := 8 * net.IPv6len
+	if r.Destination.To4() != nil {
+		bits = 8 * net.IPv4len
+	}
+	switch ones, err := strconv.Atoi(aux.Mask); {
+	case aux.Mask == "" && r.Destination.IsUnspecified():
+		r.Mask = net.CIDRMask(0, bits)
+	case aux.Mask == "":
+		r.Mask = net.CIDRMask(bits, bits)
+	case err == nil:
+		if ones < 0 || ones > bits {
+			return fmt.Errorf("invalid route mask %q for %s", aux.Mask, r.Destination)
+		}
+		r.Mask = net.CIDRMask(ones, bits)
+	default:
+		mask := net.ParseIP(aux.Mask)
+		if mask == nil {
+			return fmt.Errorf("invalid route mask %q for %s, use an address or prefix length", aux.Mask, r.Destination)
+		}
+		if bits == 8*net.IPv4len {
+			mask = mask.To4()
+		}
+		r.Mask = net.IPMask(mask)
+	}
+	return nil
+}
+
+// Contains reports whether the route covers ip.
+func (r Route) Contains(ip net.IP) bool {
+	network := net.IPNet{IP: r.Destination, Mask: r.Mask}
+	if (ip.To4() == nil) != (r.Destination.To4() == nil) {
+		return false
+	}
+	return network.Contains(ip)
+}
+
+// LookupRoute returns the route the kernel would pick for destination:
+// of the usable routes covering it the one with the longest mask, and
+// of those the one with the lowest metric.
+func LookupRoute(routes []Route, destination net.IP) (Route, bool) {
+	var best Route
+	found := false
+	for _, route := range routes {
+		if route.Flags&RouteFlagUp == 0 || route.Flags&RouteFlagReject != 0 || !route.Contains(destination) {
+			continue
+		}
+		if !found {
+			best, found = route, true
+			continue
+		}
+		ones, _ := route.Mask.Size()
+		bestOnes, _ := best.Mask.Size()
+		if ones > bestOnes || ones == bestOnes && route.Metric < best.Metric {
+			best = route
+		}
+	}
+	return best, found
+}
+
+// GetRoutes parses /proc/net/route: Iface, Destination, Gateway, Flags,
+// RefCnt, Use, Metric, Mask, MTU, Window and IRTT after a header line.
+func GetRoutes(file io.Reader) ([]Route, error) {
+	routes := []Route{}
+	scanner := bufio.NewScanner(file)
+	header := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if header {
+			header = false
+			continue
+		}
+		if len(fields) < 11 {
+			return nil, fmt.Errorf("wrong number of fields (expected 11, got %d): %s", len(fields), line)
+		}
+		destination, err := parseIP(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		gateway, err := parseIP(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flags in %s: %v", line, err)
+		}
+		mask, err := parseIP(fields[7])
+		if err != nil {
+			return nil, err
+		}
+		var numbers [6]int
+		for i, field := range []string{fields[4], fields[5], fields[6], fields[8], fields[9], fields[10]} {
+			if numbers[i], err = strconv.Atoi(field); err != nil {
+				return nil, fmt.Errorf("invalid number in %s: %v", line, err)
+			}
+		}
+		routes = append(routes, Route{
+			Interface:   fields[0],
+			Destination: destination,
+			Gateway:     gateway,
+			Flags:       uint32(flags),
+			RefCnt:      numbers[0],
+			Use:         numbers[1],
+			Metric:      numbers[2],
+			Mask:        net.IPMask(mask.To4()),
+			MTU:         numbers[3],
+			Window:      numbers[4],
+			IRTT:        numbers[5],
+		})
+	}
+	return routes, scanner.Err()
+}
+
+func parseIP(str string) (net.IP, error) {
+	bytes, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	switch len(bytes) {
+	case net.IPv4len:
+		// /proc/net/route holds IPv4 addresses in host byte order.
+		bytes[0], bytes[1], bytes[2], bytes[3] = bytes[3], bytes[2], bytes[1], bytes[0]
+	case net.IPv6len:
+		// /proc/net/ipv6_route holds them in network byte order.
+	default:
+		return nil, fmt.Errorf("unexpected address length %d in %s", len(bytes), str)
+	}
+	return net.IP(bytes), nil
+}
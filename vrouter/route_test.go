@@ -0,0 +1,136 @@
+package vrouter
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+func testRoute(intf string, cidr string, gateway string, metric int, flags uint32) Route {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return Route{
+		Interface:   intf,
+		Destination: network.IP,
+		Gateway:     net.ParseIP(gateway),
+		Mask:        network.Mask,
+		Metric:      metric,
+		Flags:       flags,
+	}
+}
+
+func TestLookupRoute(t *testing.T) {
+	routes := []Route{
+		testRoute("eth0", "0.0.0.0/0", "192.168.1.1", 100, RouteFlagUp|RouteFlagGateway),
+		testRoute("eth1", "0.0.0.0/0", "10.20.0.1", 50, RouteFlagUp|RouteFlagGateway),
+		testRoute("eth1", "10.20.0.0/16", "", 0, RouteFlagUp),
+		testRoute("eth0", "10.20.30.0/24", "192.168.1.254", 0, RouteFlagUp|RouteFlagGateway),
+		testRoute("eth2", "10.20.30.40/32", "", 0, RouteFlagUp|RouteFlagHost),
+		testRoute("eth0", "192.0.2.0/24", "", 0, 0),
+		testRoute("eth1", "::/0", "fd00:20::1", 1024, RouteFlagUp|RouteFlagGateway),
+		testRoute("eth1", "fd00:20::/64", "", 256, RouteFlagUp),
+	}
+	tests := []struct {
+		destination string
+		intf        string
+		gateway     string
+		found       bool
+	}{
+		{"8.8.8.8", "eth1", "10.20.0.1", true},
+		{"10.20.1.1", "eth1", "<nil>", true},
+		{"10.20.30.1", "eth0", "192.168.1.254", true},
+		{"10.20.30.40", "eth2", "<nil>", true},
+		{"192.0.2.1", "eth1", "10.20.0.1", true},
+		{"fd00:20::5", "eth1", "<nil>", true},
+		{"2001:db8::1", "eth1", "fd00:20::1", true},
+	}
+	for _, test := range tests {
+		route, found := LookupRoute(routes, net.ParseIP(test.destination))
+		if found != test.found || route.Interface != test.intf || route.Gateway.String() != test.gateway {
+			t.Errorf("%s: got %s via %s (%t), want %s via %s", test.destination, route.Interface, route.Gateway, found, test.intf, test.gateway)
+		}
+	}
+	if route, found := LookupRoute(routes[:1], net.ParseIP("fd00::1")); found {
+		t.Errorf("IPv6 destination matched IPv4 route %+v", route)
+	}
+}
+
+const testRoutes = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0101A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0001A8C0	00000000	0001	1	2	0	00FFFFFF	1500	0	0
+`
+
+func TestGetRoutes(t *testing.T) {
+	routes, err := GetRoutes(strings.NewReader(testRoutes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Route{
+		testRoute("eth0", "0.0.0.0/0", "192.168.1.1", 100, RouteFlagUp|RouteFlagGateway),
+		testRoute("eth0", "192.168.1.0/24", "0.0.0.0", 0, RouteFlagUp),
+	}
+	want[1].RefCnt, want[1].Use, want[1].MTU = 1, 2, 1500
+	if len(routes) != len(want) {
+		t.Fatalf("got %d routes, want %d", len(routes), len(want))
+	}
+	for i, route := range routes {
+		// /proc/net/route holds the addresses in host byte order.
+		if !route.Destination.Equal(want[i].Destination) || !route.Gateway.Equal(want[i].Gateway) ||
+			route.Mask.String() != want[i].Mask.String() || route.Interface != want[i].Interface ||
+			route.Flags != want[i].Flags || route.Metric != want[i].Metric ||
+			route.RefCnt != want[i].RefCnt || route.Use != want[i].Use || route.MTU != want[i].MTU {
+			t.Errorf("route %d: got %+v, want %+v", i, route, want[i])
+		}
+	}
+}
+
+func TestRouteJSON(t *testing.T) {
+	tests := []struct {
+		json string
+		mask string
+		err  string
+	}{
+		{`{"destination": "10.0.0.0", "mask": "255.255.255.0"}`, "ffffff00", ""},
+		{`{"destination": "10.0.0.0", "mask": "16"}`, "ffff0000", ""},
+		{`{"destination": "0.0.0.0"}`, "00000000", ""},
+		{`{"destination": "10.0.0.5"}`, "ffffffff", ""},
+		{`{"destination": "::"}`, "00000000000000000000000000000000", ""},
+		{`{"destination": "fd00::", "mask": "64"}`, "ffffffffffffffff0000000000000000", ""},
+		{`{"destination": "10.0.0.0", "mask": "33"}`, "", "invalid route mask"},
+		{`{"destination": "10.0.0.0", "mask": "x"}`, "", "invalid route mask"},
+		{`{"mask": "24"}`, "", "route without destination"},
+	}
+	for _, test := range tests {
+		var route Route
+		err := json.Unmarshal([]byte(test.json), &route)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, want %q", test.json, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.json, err)
+			continue
+		}
+		if route.Mask.String() != test.mask {
+			t.Errorf("%s: got mask %s, want %s", test.json, route.Mask, test.mask)
+		}
+	}
+
+	route := testRoute("eth0", "10.20.0.0/16", "10.20.0.1", 0, RouteFlagUp|RouteFlagGateway)
+	b, err := json.Marshal(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Route
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Mask.String() != route.Mask.String() || !decoded.Destination.Equal(route.Destination) {
+		t.Errorf("round trip: got %s/%s, want %s/%s", decoded.Destination, decoded.Mask, route.Destination, route.Mask)
+	}
+}
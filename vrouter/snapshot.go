@@ -0,0 +1,183 @@
+package vrouter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/michaelhenkel/contrail-init/k8s"
+)
+
+// mainTable is the routing table /proc/net/route shows and lookups use.
+const mainTable = 254
+
+// Snapshot is the network configuration of the node as seen by
+// discovery.
+type Snapshot struct {
+	// Source is the backend that took the snapshot, netlink or proc.
+	Source string  `json:"source"`
+	Links  []Link  `json:"links"`
+	Routes []Route `json:"routes"`
+}
+
+// Link is a network interface with its addresses.
+type Link struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	// Kind is the link type from rtnetlink, e.g. bond, vlan, team or
+	// veth, and empty for physical devices.
+	Kind string `json:"kind,omitempty"`
+	MAC  string `json:"mac,omitempty"`
+	MTU  int    `json:"mtu"`
+	Up   bool   `json:"up"`
+	// MasterIndex is the bond, team or bridge the link is enslaved to.
+	MasterIndex int `json:"masterIndex,omitempty"`
+	// ParentIndex is the link a vlan or macvlan is stacked on.
+	ParentIndex int `json:"parentIndex,omitempty"`
+	// VLANID is set for vlan links.
+	VLANID int `json:"vlanID,omitempty"`
+	// BondMode is set for bond links, e.g. 802.3ad.
+	BondMode string `json:"bondMode,omitempty"`
+	// PCIAddress is the PCI device of physical links, e.g. 0000:3b:00.0.
+	PCIAddress string    `json:"pci,omitempty"`
+	Addresses  []Address `json:"addresses,omitempty"`
+}
+
+type Address struct {
+	IP           net.IP `json:"ip"`
+	PrefixLength int    `json:"prefixLength"`
+	Secondary    bool   `json:"secondary,omitempty"`
+}
+
+// ipMask returns the address and prefix length as rendered, or empty
+// strings for unknown values.
+func (a Address) ipMask() (string, string) {
+	if a.IP == nil {
+		return "", ""
+	}
+	if a.PrefixLength == 0 {
+		return a.IP.String(), ""
+	}
+	return a.IP.String(), strconv.Itoa(a.PrefixLength)
+}
+
+func procSnapshot() (*Snapshot, error) {
+	snapshot := &Snapshot{Source: "proc"}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		link := Link{
+			Index: iface.Index,
+			Name:  iface.Name,
+			MAC:   iface.HardwareAddr.String(),
+			MTU:   iface.MTU,
+			Up:    iface.Flags&net.FlagUp != 0,
+		}
+		ifaceAddresses, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, ifaceAddress := range ifaceAddresses {
+			if addressValue, ok := ifaceAddress.(*net.IPNet); ok {
+				size, _ := addressValue.Mask.Size()
+				link.Addresses = append(link.Addresses, Address{IP: addressValue.IP, PrefixLength: size})
+			}
+		}
+		snapshot.Links = append(snapshot.Links, link)
+	}
+	sysfsHierarchy(snapshot.Links)
+
+	dat, err := ioutil.ReadFile("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	routes, err := GetRoutes(bytes.NewReader(dat))
+	if err != nil {
+		return nil, err
+	}
+	dat, err = ioutil.ReadFile("/proc/net/ipv6_route")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	routes6, err := GetIPv6Routes(bytes.NewReader(dat))
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range append(routes, routes6...) {
+		route.Table = mainTable
+		snapshot.Routes = append(snapshot.Routes, route)
+	}
+	return snapshot, nil
+}
+
+func (s *Snapshot) LinkByName(name string) (Link, bool) {
+	for _, link := range s.Links {
+		if link.Name == name {
+			return link, true
+		}
+	}
+	return Link{}, false
+}
+
+// linkAddress returns the link holding ip and the address entry.
+func (s *Snapshot) linkAddress(ip string) (Link, Address, bool) {
+	for _, link := range s.Links {
+		for _, address := range link.Addresses {
+			if address.IP.String() == ip {
+				return link, address, true
+			}
+		}
+	}
+	return Link{}, Address{}, false
+}
+
+// Interface returns the physical interface holding ip. Once vhost0 took
+// over the interface it holds the address, see physical.
+func (s *Snapshot) Interface(ip string) string {
+	if _, ok := s.LinkByName("vhost0"); ok {
+		return s.physical("vhost0")
+	}
+	link, _, _ := s.linkAddress(ip)
+	return link.Name
+}
+
+// physical maps vhost0 to the top-level link sharing its MAC address and
+// returns other links unchanged.
+func (s *Snapshot) physical(name string) string {
+	vhost, ok := s.LinkByName("vhost0")
+	if name != "vhost0" || !ok {
+		return name
+	}
+	var candidates []Link
+	for _, link := range s.Links {
+		if link.MAC == vhost.MAC && link.Name != "vhost0" {
+			candidates = append(candidates, link)
+		}
+	}
+	return s.topLevel(candidates)
+}
+
+// MainRoutes returns the routes of the main table of one family.
+func (s *Snapshot) MainRoutes(ipv6 bool) []Route {
+	var routes []Route
+	for _, route := range s.Routes {
+		if route.Table == mainTable && (route.Destination.To4() == nil) == ipv6 {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// Gateway returns the gateway of intf in one family, see
+// interfaceGateway.
+func (s *Snapshot) Gateway(intf string, ipv6 bool, controlNode k8s.Endpoint) (string, error) {
+	zero := net.IPv4zero
+	if ipv6 {
+		zero = net.IPv6zero
+	}
+	return interfaceGateway(s.MainRoutes(ipv6), intf, zero, controlNode)
+}
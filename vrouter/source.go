@@ -0,0 +1,65 @@
+package vrouter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// NetworkSource provides the snapshot vrouter discovery works on.
+type NetworkSource interface {
+	Snapshot() (*Snapshot, error)
+}
+
+// HostNetwork discovers the network of this node through netlink and
+// falls back to net.Interfaces and /proc where netlink is not available.
+type HostNetwork struct{}
+
+func (HostNetwork) Snapshot() (*Snapshot, error) {
+	snapshot, err := netlinkSnapshot()
+	if err != nil {
+		fmt.Printf("netlink discovery failed, falling back to /proc: %v\n", err)
+		snapshot, err = procSnapshot()
+		if err != nil {
+			return nil, err
+		}
+	}
+	sysfsPCIAddresses(snapshot.Links)
+	return snapshot, nil
+}
+
+// FixtureNetwork replays a snapshot recorded with the snapshot command,
+// e.g. to render the config of another node or to reproduce an edge case.
+type FixtureNetwork struct {
+	Path string
+}
+
+func (f FixtureNetwork) Snapshot() (*Snapshot, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	snapshot, err := ReadSnapshot(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f.Path, err)
+	}
+	return snapshot, nil
+}
+
+// WriteSnapshot writes a snapshot as JSON.
+func WriteSnapshot(w io.Writer, snapshot *Snapshot) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snapshot)
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	if err := json.NewDecoder(r).Decode(snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
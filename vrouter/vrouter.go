@@ -1,58 +1,274 @@
 package vrouter
 
 import (
-	"bufio"
-	"bytes"
-	"context"
-	"encoding/hex"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net"
 	"strconv"
 	"strings"
 
 	"github.com/michaelhenkel/contrail-init/k8s"
+	"github.com/michaelhenkel/contrail-init/registry"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Labels set on the pod for the vrouter kernel init container.
+const (
+	controlNodeNameLabel = "controlNodeName"
+	interfaceLabel       = "interface"
+	vlanIDLabel          = "vlanID"
+	bondModeLabel        = "bondMode"
+	mtuLabel             = "mtu"
 )
 
 type Vrouter struct {
 	K8S *k8s.K8S
+	// Network is where the node's links and routes are discovered.
+	Network NetworkSource
+
+	controlNode k8s.Endpoint
+	controlIP   string
+	intf        string
+	link        Link
+	vlanParent  string
+	bondMode    string
+	slaves      []string
+	mtu         int
+	ip          string
+	mask        string
+	gateway     string
+	ip6         string
+	mask6       string
+	gateway6    string
+	collectors  string
+	dnsServers  string
 }
 
-func (v *Vrouter) CreateConfig() error {
-	controlNodeName, controlNodePort, err := v.GetControlNode()
+func init() {
+	registry.Register(registry.Role{
+		Name:        "contrail-vrouter",
+		Aliases:     []string{"vrouter"},
+		Description: "Contrail vrouter agent: vhost0 and agent config, certificates",
+		New: func(k *k8s.K8S) registry.ContrailInit {
+			var network NetworkSource = HostNetwork{}
+			if k.NetworkSnapshot != "" {
+				network = FixtureNetwork{Path: k.NetworkSnapshot}
+			}
+			return &Vrouter{K8S: k, Network: network}
+		},
+	})
+}
+
+func (v *Vrouter) Discover() error {
+	var err error
+	v.controlNode, err = v.GetControlNode()
+	if err != nil {
+		return err
+	}
+	network, err := v.Network.Snapshot()
 	if err != nil {
 		return err
 	}
-	v.K8S.Pod.Labels["controlNodeName"] = controlNodeName
-	intf, err := getInterface(v.K8S.PodIP)
+	policy, source, err := v.K8S.Setting(InterfacePolicyKey)
 	if err != nil {
 		return err
 	}
-	mask, err := getCIDR(v.K8S.PodIP)
+	if policy != "" {
+		v.intf, err = network.SelectInterface(policy, v.controlNode)
+		if err != nil {
+			return fmt.Errorf("%s: %v", source, err)
+		}
+		fmt.Printf("interface %s selected by %s from %s\n", v.intf, policy, source)
+	}
+	podIP4, podIP6 := splitFamilies(v.K8S.PodIPs)
+	var data4, data6 Address
+	selector, source, err := v.K8S.Setting(VhostIPKey)
 	if err != nil {
 		return err
 	}
-	var gateway string
-	if gw, ok := v.K8S.OwnerLabels["Gateway"]; ok {
-		gateway = gw
+	if selector != "" {
+		selector = "ip=" + selector
+	} else if selector, source, err = v.K8S.Setting(DataNetworkKey); err != nil {
+		return err
+	}
+	switch {
+	case selector != "":
+	case policy != "":
+		selector, source = "interface="+v.intf, "interface policy"
+	}
+	if selector != "" {
+		data4, data6, err = network.SelectAddresses(selector)
+		if err != nil {
+			return fmt.Errorf("%s: %v", source, err)
+		}
+		fmt.Printf("data network address %s selected by %s from %s\n", joinAddresses(data4, data6), selector, source)
 	} else {
-		gateway, err = getGateway(intf)
+		data4, data6 = podAddress(network, podIP4), podAddress(network, podIP6)
+		fmt.Printf("data network address %s from the pod IP\n", joinAddresses(data4, data6))
+	}
+	v.ip, v.mask = data4.ipMask()
+	v.ip6, v.mask6 = data6.ipMask()
+	if err := v.overrideMask(); err != nil {
+		return err
+	}
+	control4, control6 := podIP4, podIP6
+	selector, source, err = v.K8S.Setting(ControlNetworkKey)
+	if err != nil {
+		return err
+	}
+	if selector != "" {
+		ip4, ip6, err := network.SelectAddresses(selector)
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %v", source, err)
 		}
+		control4, _ = ip4.ipMask()
+		control6, _ = ip6.ipMask()
+		fmt.Printf("control network address %s selected by %s from %s\n", joinAddresses(ip4, ip6), selector, source)
+	}
+	v.controlIP = control4
+	if v.controlIP == "" {
+		v.controlIP = control6
+	}
+	if policy == "" {
+		policy = "data-ip"
+		v.intf = network.Interface(v.primaryIP())
+		fmt.Printf("interface %s selected by the data network address %s\n", v.intf, v.primaryIP())
+	}
+	v.link, _ = network.LinkByName(v.intf)
+	// A vlan may sit on a bond or team, whose details are needed as well.
+	bond := v.link
+	if v.link.VLANID != 0 {
+		bond, _ = network.LinkByIndex(v.link.ParentIndex)
+		v.vlanParent = bond.Name
+	}
+	v.bondMode = bond.BondMode
+	v.slaves = nil
+	for _, slave := range network.Slaves(bond) {
+		v.slaves = append(v.slaves, slave.Name)
 	}
-	if err := v.K8S.UpdatePOD(); err != nil {
+	if err := v.overrideMTU(); err != nil {
 		return err
 	}
+	if err := v.gateways(network); err != nil {
+		return err
+	}
+	v.collectors, err = v.K8S.Collectors()
+	if err != nil {
+		return err
+	}
+	v.dnsServers, err = v.K8S.DNSServers()
+	if err != nil {
+		return err
+	}
+	if len(validation.IsValidLabelValue(v.controlNode.Host())) > 0 {
+		fmt.Printf("control node %s is no valid label value, not setting %s\n", v.controlNode.Host(), controlNodeNameLabel)
+	}
+	v.K8S.AddFact("controlNode", v.controlNode.String())
+	v.K8S.AddFact("controlNetworkIP", v.controlIP)
+	v.K8S.AddFact("networkSource", network.Source)
+	v.K8S.AddFact("interface", v.intf)
+	v.K8S.AddFact("interfacePolicy", policy)
+	v.K8S.AddFact("mac", v.link.MAC)
+	v.K8S.AddFact("mtu", strconv.Itoa(v.mtu))
+	if v.link.Kind != "" {
+		v.K8S.AddFact("interfaceKind", v.link.Kind)
+	}
+	if len(v.slaves) > 0 {
+		v.K8S.AddFact("slaves", strings.Join(v.slaves, ","))
+	}
+	if v.bondMode != "" {
+		v.K8S.AddFact("bondMode", v.bondMode)
+	}
+	if v.link.VLANID != 0 {
+		v.K8S.AddFact("vlanID", strconv.Itoa(v.link.VLANID))
+		v.K8S.AddFact("vlanParent", v.vlanParent)
+	}
+	if v.ip != "" {
+		v.K8S.AddFact("ip", v.ip)
+		v.K8S.AddFact("mask", v.mask)
+		v.K8S.AddFact("gateway", v.gateway)
+	}
+	if v.ip6 != "" {
+		v.K8S.AddFact("ip6", v.ip6)
+		v.K8S.AddFact("mask6", v.mask6)
+		v.K8S.AddFact("gateway6", v.gateway6)
+	}
+	return nil
+}
+
+// splitFamilies returns the first IPv4 and the first IPv6 address.
+func splitFamilies(ips []string) (string, string) {
+	var ip4, ip6 string
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		switch {
+		case parsed == nil:
+		case parsed.To4() != nil && ip4 == "":
+			ip4 = ip
+		case parsed.To4() == nil && ip6 == "":
+			ip6 = ip
+		}
+	}
+	return ip4, ip6
+}
+
+// podAddress returns the pod IP ip with the prefix length of the link
+// holding it.
+func podAddress(network *Snapshot, ip string) Address {
+	if ip == "" {
+		return Address{}
+	}
+	if _, address, ok := network.linkAddress(ip); ok {
+		return address
+	}
+	return Address{IP: net.ParseIP(ip)}
+}
+
+// primaryIP is the data network address vhost0 takes, IPv4 on dual-stack
+// clusters.
+func (v *Vrouter) primaryIP() string {
+	if v.ip != "" {
+		return v.ip
+	}
+	return v.ip6
+}
+
+func (v *Vrouter) Validate() error {
+	if v.primaryIP() == "" {
+		return fmt.Errorf("no data network address, the pod has no IP")
+	}
+	if v.controlIP == "" {
+		return fmt.Errorf("no control network address, the pod has no IP")
+	}
+	if v.intf == "" {
+		return fmt.Errorf("no interface holds the data network address %s, set %s", v.primaryIP(), InterfacePolicyKey)
+	}
+	if v.ip != "" {
+		if v.mask == "" {
+			return fmt.Errorf("no prefix length found for the data network address %s", v.ip)
+		}
+		if v.gateway == "" {
+			return fmt.Errorf("no gateway found for interface %s", v.intf)
+		}
+	}
+	if v.ip6 != "" {
+		if v.mask6 == "" {
+			return fmt.Errorf("no prefix length found for the data network address %s", v.ip6)
+		}
+		if v.gateway6 == "" {
+			return fmt.Errorf("no IPv6 gateway found for interface %s", v.intf)
+		}
+	}
+	return nil
+}
+
+func (v *Vrouter) Render() (*k8s.Rendered, error) {
 	vrouterConfig := `[CONTROL-NODE]
-server=` + controlNodeName + `:` + strconv.Itoa((int(controlNodePort))) + `
+server=` + v.controlNode.String() + `
 [DEFAULT]
 debug=1
 hostname=` + v.K8S.Hostname + `
-# http_server_port=8085
+` + k8s.CollectorsSetting(v.collectors) + `# http_server_port=8085
 # log_category=
 # log_file=/var/log/contrail/vrouter.log
 # log_level=SYS_DEBUG
@@ -62,8 +278,7 @@ hostname=` + v.K8S.Hostname + `
 # headless_mode=
 [DISCOVERY]
 [DNS]
-# server=
-[HYPERVISOR]
+` + dnsSetting(v.dnsServers) + `[HYPERVISOR]
 # type=kvm
 [FLOWS]
 # max_vm_flows=
@@ -72,189 +287,138 @@ hostname=` + v.K8S.Hostname + `
 [METADATA]
 # metadata_proxy_secret=contrail
 [NETWORKS]
-control_network_ip=` + v.K8S.PodIP + `
+control_network_ip=` + v.controlIP + `
 [VIRTUAL-HOST-INTERFACE]
 name=vhost0
-ip=` + v.K8S.PodIP + `/` + mask + `
-gateway=` + gateway + `
-physical_interface=` + intf + `
-[GATEWAY-0]
+` + v.vhostAddresses() + mtuSetting(v.mtu) + `physical_interface=` + v.intf + `
+physical_interface_mac=` + v.link.MAC + `
+` + v.hierarchySettings() + `[GATEWAY-0]
 [GATEWAY-1]
 [SERVICE-INSTANCE]
 netns_command=/usr/bin/opencontrail-vrouter-netns
 #netns_workers=1
 #netns_timeout=30`
 
-	return v.K8S.CreateConfig(vrouterConfig, "contrail-vrouter-"+v.K8S.Hostname+".conf")
+	return &k8s.Rendered{
+		Config:      map[string]string{v.configName(): vrouterConfig},
+		PodLabels:   v.podLabels(),
+		Certificate: true,
+	}, nil
 }
 
-func getInterface(podIP string) (string, error) {
-	var intf string
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		return intf, err
-	}
-	vhostExists := false
-	var vhostMac net.HardwareAddr
-	for _, iface := range ifaces {
-		if iface.Name == "vhost0" {
-			vhostExists = true
-			vhostMac = iface.HardwareAddr
-		}
+// podLabels passes the control node and the physical interface to the
+// vrouter kernel init container, which creates vhost0 on top of the bond
+// or vlan. Values that are no valid label values, e.g. IPv6 addresses,
+// are left out.
+func (v *Vrouter) podLabels() map[string]string {
+	labels := map[string]string{
+		controlNodeNameLabel: v.controlNode.Host(),
+		interfaceLabel:       v.intf,
+		bondModeLabel:        v.bondMode,
 	}
-	if vhostExists {
-		for _, iface := range ifaces {
-			if iface.HardwareAddr.String() == vhostMac.String() && iface.Name != "vhost0" {
-				intf = iface.Name
-			}
-		}
-	} else {
-		for _, iface := range ifaces {
-			ifaceAddresses, err := iface.Addrs()
-			if err != nil {
-				return intf, err
-			}
-			for _, ifaceAddress := range ifaceAddresses {
-				switch addressValue := ifaceAddress.(type) {
-				case *net.IPNet:
-					if addressValue.IP.String() == podIP {
-						intf = iface.Name
-					}
-				}
-			}
+	if v.mtu != 0 {
+		labels[mtuLabel] = strconv.Itoa(v.mtu)
+	}
+	if v.link.VLANID != 0 {
+		labels[vlanIDLabel] = strconv.Itoa(v.link.VLANID)
+	}
+	for key, value := range labels {
+		if value == "" || len(validation.IsValidLabelValue(value)) > 0 {
+			delete(labels, key)
 		}
 	}
-	return intf, nil
+	return labels
 }
 
-func getCIDR(podIP string) (string, error) {
-	var mask string
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		return mask, err
+// vhostAddresses returns the vhost0 address and gateway settings of each
+// family the pod has an address in.
+func (v *Vrouter) vhostAddresses() string {
+	var settings string
+	if v.ip != "" {
+		settings += "ip=" + v.ip + "/" + v.mask + "\ngateway=" + v.gateway + "\n"
 	}
-	for _, iface := range ifaces {
-		ifaceAddresses, err := iface.Addrs()
-		if err != nil {
-			return mask, err
-		}
-		for _, ifaceAddress := range ifaceAddresses {
-			switch addressValue := ifaceAddress.(type) {
-			case *net.IPNet:
-				if addressValue.IP.String() == podIP {
-					size, _ := addressValue.Mask.Size()
-					mask = strconv.Itoa(size)
-				}
-			}
-		}
+	if v.ip6 != "" {
+		settings += "ip6=" + v.ip6 + "/" + v.mask6 + "\ngateway6=" + v.gateway6 + "\n"
 	}
-	return mask, nil
+	return settings
 }
 
-func getGateway(intf string) (string, error) {
-	var gateway string
-	dat, err := ioutil.ReadFile("/proc/net/route")
-	if err != nil {
-		fmt.Printf("ERROR: %v", err)
-		return gateway, err
+// hierarchySettings returns the vlan and bond settings of the physical
+// interface, if it is a vlan or bond or a vlan on a bond.
+func (v *Vrouter) hierarchySettings() string {
+	var settings string
+	if v.link.VLANID != 0 {
+		settings += "physical_interface_vlan_id=" + strconv.Itoa(v.link.VLANID) + "\nphysical_interface_vlan_parent=" + v.vlanParent + "\n"
 	}
-	r := bytes.NewReader(dat)
-	routes, err := GetRoutes(r)
-	if err != nil {
-		fmt.Printf("ERROR: %v", err)
-		return gateway, err
-	}
-	for i := range routes {
-		zero := net.IP{0, 0, 0, 0}
-		if routes[i].Destination.Equal(zero) {
-			gateway = routes[i].Gateway.String()
-			fmt.Println("gateway:", gateway)
-			break
-		}
+	if v.bondMode != "" {
+		settings += "physical_interface_bond_mode=" + v.bondMode + "\n"
+	}
+	if len(v.slaves) > 0 {
+		settings += "physical_interface_bond_slaves=" + strings.Join(v.slaves, " ") + "\n"
 	}
-	return gateway, nil
+	return settings
 }
 
-func (v *Vrouter) CreateCertificate() error {
-	return v.K8S.CreateCertificate()
+// mtuSetting returns the vhost0 mtu= line, or nothing if the MTU is not
+// known.
+func mtuSetting(mtu int) string {
+	if mtu == 0 {
+		return ""
+	}
+	return "mtu=" + strconv.Itoa(mtu) + "\n"
 }
 
-func (v *Vrouter) SetOwnerNameLabel() error {
-	return v.K8S.SetOwnerNameLabel()
+// dnsSetting returns the servers= line for the [DNS] section, or the
+// commented default while there is no contrail-dns.
+func dnsSetting(servers string) string {
+	if servers == "" {
+		return "# servers=\n"
+	}
+	return "servers=" + servers + "\n"
 }
 
-func (v *Vrouter) GetControlNode() (string, int32, error) {
-	controlNodeName, ok := v.K8S.OwnerLabels["contrail-control-instance"]
-	if !ok {
-		controlNodeName = "contrail-control"
+func (v *Vrouter) configName() string {
+	return "contrail-vrouter-" + v.K8S.Hostname + ".conf"
+}
+
+// interfaceGateway returns the gateway of the default route egressing
+// intf or, if there is none, of the route toward the control node. Once
+// vhost0 took over intf its routes are on vhost0. zero is the unspecified
+// address of the family to look up.
+func interfaceGateway(routes []Route, intf string, zero net.IP, controlNode k8s.Endpoint) (string, error) {
+	var interfaceRoutes []Route
+	for _, route := range routes {
+		if route.Interface == intf || route.Interface == "vhost0" {
+			interfaceRoutes = append(interfaceRoutes, route)
+		}
 	}
-	ctx := context.Background()
-	controlNodeService, err := v.K8S.ClientSet.CoreV1().Services(v.K8S.Namespace).Get(ctx, controlNodeName, metav1.GetOptions{})
-	if err != nil {
-		return "", 0, err
+	if route, ok := LookupRoute(interfaceRoutes, zero); ok && route.Flags&RouteFlagGateway != 0 {
+		return route.Gateway.String(), nil
 	}
-	var controlNodePort int32
-	controlNodePort = 5269
-	for _, port := range controlNodeService.Spec.Ports {
-		if port.Name == "xmpp" {
-			controlNodePort = port.Port
+	if control, ok := controlNode.Family(zero.To4() == nil); ok {
+		if route, ok := LookupRoute(interfaceRoutes, control.IPs[0]); ok && route.Flags&RouteFlagGateway != 0 {
+			return route.Gateway.String(), nil
 		}
+		return "", fmt.Errorf("interface %s has no default route and no route to the control node %s with a gateway", intf, control.Host())
 	}
-
-	return controlNodeService.Spec.ClusterIP, controlNodePort, nil
+	return "", fmt.Errorf("interface %s has no default route with a gateway", intf)
 }
 
-type Route struct {
-	Interface   string
-	Destination net.IP
-	Gateway     net.IP
-	// TODO: add more fields here if needed
+func (v *Vrouter) Teardown() error {
+	return v.K8S.Teardown([]string{v.configName()}, []string{controlNodeNameLabel, interfaceLabel, vlanIDLabel, bondModeLabel, mtuLabel})
 }
 
-func GetRoutes(file io.Reader) ([]Route, error) {
-	routes := []Route{}
-	scanner := bufio.NewReader(file)
-	lineNum := 0
-	for {
-		line, err := scanner.ReadString('\n')
-		if err == io.EOF {
-			fmt.Println(err)
-			break
-		}
-		fields := strings.Fields(line)
-		if len(fields) < 3 {
-			return nil, fmt.Errorf("wrong number of fields (expected at least 3, got %d): %s", len(fields), line)
-		}
-		lineNum++
-		if lineNum == 1 {
-			continue // skip header
-		}
-		routes = append(routes, Route{})
-		route := &routes[len(routes)-1]
-		route.Interface = fields[0]
-		ip, err := parseIP(fields[1])
-		if err != nil {
-			return nil, err
-		}
-		route.Destination = ip
-		ip, err = parseIP(fields[2])
-		if err != nil {
-			return nil, err
-		}
-		route.Gateway = ip
+func (v *Vrouter) GetControlNode() (k8s.Endpoint, error) {
+	controlNodeName, ok := v.K8S.OwnerLabels["contrail-control-instance"]
+	if !ok {
+		controlNodeName = "contrail-control"
 	}
-	return routes, nil
-}
-
-func parseIP(str string) (net.IP, error) {
-	bytes, err := hex.DecodeString(str)
+	controlNode, found, err := v.K8S.ServiceAddress(controlNodeName, "xmpp", 5269)
 	if err != nil {
-		return nil, err
+		return k8s.Endpoint{}, err
 	}
-	if len(bytes) != net.IPv4len {
-		// TODO: IPv6 support
-		return nil, fmt.Errorf("only IPv4 is supported")
+	if !found {
+		return k8s.Endpoint{}, fmt.Errorf("control node service %s not found", controlNodeName)
 	}
-	bytes[0], bytes[1], bytes[2], bytes[3] = bytes[3], bytes[2], bytes[1], bytes[0]
-	return net.IP(bytes), nil
+	return controlNode, nil
 }
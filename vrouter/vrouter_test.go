@@ -0,0 +1,321 @@
+package vrouter
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/michaelhenkel/contrail-init/k8s"
+)
+
+const (
+	testNamespace = "contrail"
+	testHostname  = "worker-1"
+)
+
+// testVrouter returns a vrouter discovering the network from a fixture in
+// testdata, with a contrail-control service at controlIP and the node
+// annotated with annotations.
+func testVrouter(fixture string, podIPs []string, controlIP string, annotations map[string]string) *Vrouter {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: testHostname, Annotations: annotations},
+	}
+	control := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "contrail-control", Namespace: testNamespace},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:  controlIP,
+			ClusterIPs: []string{controlIP},
+			Ports:      []corev1.ServicePort{{Name: "xmpp", Port: 5269}},
+		},
+	}
+	return &Vrouter{
+		K8S: &k8s.K8S{
+			Namespace: testNamespace,
+			Hostname:  testHostname,
+			ClientSet: fake.NewSimpleClientset(node, control),
+			PodIPs:    podIPs,
+		},
+		Network: FixtureNetwork{Path: filepath.Join("testdata", fixture)},
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		name           string
+		fixture        string
+		podIPs         []string
+		controlService string
+		annotations    map[string]string
+
+		intf      string
+		ip        string
+		mask      string
+		gateway   string
+		ip6       string
+		mask6     string
+		gateway6  string
+		controlIP string
+		slaves    []string
+		bondMode  string
+		vlanID    int
+		mtu       int
+		err       string
+	}{
+		{
+			name:           "vhost0 already present",
+			fixture:        "vhost0.json",
+			podIPs:         []string{"10.0.0.5"},
+			controlService: "10.96.0.10",
+			intf:           "ens3",
+			ip:             "10.0.0.5",
+			mask:           "24",
+			gateway:        "10.0.0.1",
+			controlIP:      "10.0.0.5",
+			mtu:            9000,
+		},
+		{
+			name:           "vlan on bond",
+			fixture:        "bond.json",
+			podIPs:         []string{"10.10.0.5"},
+			controlService: "10.96.0.10",
+			intf:           "bond0.100",
+			ip:             "10.10.0.5",
+			mask:           "24",
+			gateway:        "10.10.0.1",
+			controlIP:      "10.10.0.5",
+			slaves:         []string{"ens3f0", "ens3f1"},
+			bondMode:       "802.3ad",
+			vlanID:         100,
+			mtu:            9000,
+		},
+		{
+			name:           "vlan on bond selected by the shared MAC address",
+			fixture:        "bond.json",
+			podIPs:         []string{"10.10.0.5"},
+			controlService: "10.96.0.10",
+			annotations:    map[string]string{InterfacePolicyKey: "mac=52:54:00:00:01:01"},
+			intf:           "bond0.100",
+			ip:             "10.10.0.5",
+			mask:           "24",
+			gateway:        "10.10.0.1",
+			controlIP:      "10.10.0.5",
+			slaves:         []string{"ens3f0", "ens3f1"},
+			bondMode:       "802.3ad",
+			vlanID:         100,
+			mtu:            9000,
+		},
+		{
+			name:           "no default route, interface toward the control node",
+			fixture:        "no-default-route.json",
+			podIPs:         []string{"10.30.0.5"},
+			controlService: "10.96.0.10",
+			annotations:    map[string]string{InterfacePolicyKey: "route-to-control"},
+			intf:           "eth0",
+			ip:             "10.30.0.5",
+			mask:           "24",
+			gateway:        "10.30.0.1",
+			controlIP:      "10.30.0.5",
+			mtu:            1500,
+		},
+		{
+			name:           "multiple IPs, pod IP on the primary address",
+			fixture:        "multiple-ips.json",
+			podIPs:         []string{"192.168.1.10"},
+			controlService: "10.96.0.10",
+			intf:           "eth0",
+			ip:             "192.168.1.10",
+			mask:           "24",
+			gateway:        "192.168.1.1",
+			controlIP:      "192.168.1.10",
+			mtu:            1500,
+		},
+		{
+			name:           "multiple IPs, dual-stack pod IPs",
+			fixture:        "multiple-ips.json",
+			podIPs:         []string{"10.20.0.5", "fd00:20::5"},
+			controlService: "10.96.0.10",
+			intf:           "eth1",
+			ip:             "10.20.0.5",
+			mask:           "16",
+			gateway:        "10.20.0.1",
+			ip6:            "fd00:20::5",
+			mask6:          "64",
+			gateway6:       "fd00:20::1",
+			controlIP:      "10.20.0.5",
+			mtu:            9000,
+		},
+		{
+			name:           "multiple IPs, data network by CIDR",
+			fixture:        "multiple-ips.json",
+			podIPs:         []string{"192.168.1.10"},
+			controlService: "10.96.0.10",
+			annotations:    map[string]string{DataNetworkKey: "cidr=10.20.0.0/16"},
+			intf:           "eth1",
+			ip:             "10.20.0.5",
+			mask:           "16",
+			gateway:        "10.20.0.1",
+			controlIP:      "192.168.1.10",
+			mtu:            9000,
+		},
+		{
+			name:           "multiple IPs, dual-stack data network by interface",
+			fixture:        "multiple-ips.json",
+			podIPs:         []string{"192.168.1.10"},
+			controlService: "10.96.0.10",
+			annotations:    map[string]string{InterfacePolicyKey: "name=eth1"},
+			intf:           "eth1",
+			ip:             "10.20.0.5",
+			mask:           "16",
+			gateway:        "10.20.0.1",
+			ip6:            "fd00:20::5",
+			mask6:          "64",
+			gateway6:       "fd00:20::1",
+			controlIP:      "192.168.1.10",
+			mtu:            9000,
+		},
+		{
+			name:           "multiple IPs, secondary control network address",
+			fixture:        "multiple-ips.json",
+			podIPs:         []string{"192.168.1.10"},
+			controlService: "10.96.0.10",
+			annotations: map[string]string{
+				DataNetworkKey:    "interface=eth1",
+				ControlNetworkKey: "ip=192.168.1.11",
+			},
+			intf:      "eth1",
+			ip:        "10.20.0.5",
+			mask:      "16",
+			gateway:   "10.20.0.1",
+			ip6:       "fd00:20::5",
+			mask6:     "64",
+			gateway6:  "fd00:20::1",
+			controlIP: "192.168.1.11",
+			mtu:       9000,
+		},
+		{
+			name:           "multiple IPs, node overrides",
+			fixture:        "multiple-ips.json",
+			podIPs:         []string{"192.168.1.10"},
+			controlService: "10.96.0.10",
+			annotations: map[string]string{
+				InterfacePolicyKey: "eth1",
+				VhostIPKey:         "10.20.0.9",
+				MaskKey:            "255.255.255.0",
+				GatewayKey:         "10.20.0.254",
+				MTUKey:             "1400",
+				ControlNetworkKey:  "ip=192.168.1.11",
+			},
+			intf:      "eth1",
+			ip:        "10.20.0.9",
+			mask:      "24",
+			gateway:   "10.20.0.254",
+			controlIP: "192.168.1.11",
+			mtu:       1400,
+		},
+		{
+			name:           "dual-stack without IPv6 gateway",
+			fixture:        "ipv6-no-gateway.json",
+			podIPs:         []string{"10.40.0.5", "fd00:40::5"},
+			controlService: "10.96.0.10",
+			intf:           "eth0",
+			ip:             "10.40.0.5",
+			mask:           "24",
+			gateway:        "10.40.0.1",
+			controlIP:      "10.40.0.5",
+			mtu:            1500,
+		},
+		{
+			name:           "IPv6-only without IPv6 gateway",
+			fixture:        "ipv6-no-gateway.json",
+			podIPs:         []string{"fd00:40::5"},
+			controlService: "10.96.0.10",
+			err:            "interface eth0 has no default route",
+		},
+		{
+			name:           "no default route, gateway toward the control node",
+			fixture:        "no-default-route.json",
+			podIPs:         []string{"10.30.0.5"},
+			controlService: "10.96.0.10",
+			intf:           "eth0",
+			ip:             "10.30.0.5",
+			mask:           "24",
+			gateway:        "10.30.0.1",
+			controlIP:      "10.30.0.5",
+			mtu:            1500,
+		},
+		{
+			name:           "no default route and no route to the control node",
+			fixture:        "no-default-route.json",
+			podIPs:         []string{"10.30.0.5"},
+			controlService: "172.16.0.10",
+			err:            "no route to the control node 172.16.0.10",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := testVrouter(test.fixture, test.podIPs, test.controlService, test.annotations)
+			err := v.Discover()
+			if err == nil {
+				err = v.Validate()
+			}
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{v.intf, v.ip, v.mask, v.gateway, v.ip6, v.mask6, v.gateway6, v.controlIP, v.bondMode}
+			want := []string{test.intf, test.ip, test.mask, test.gateway, test.ip6, test.mask6, test.gateway6, test.controlIP, test.bondMode}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("interface, ip, mask, gateway, ip6, mask6, gateway6, control IP, bond mode:\ngot  %q\nwant %q", got, want)
+			}
+			if !reflect.DeepEqual(v.slaves, test.slaves) {
+				t.Errorf("slaves: got %q, want %q", v.slaves, test.slaves)
+			}
+			if v.link.VLANID != test.vlanID {
+				t.Errorf("vlan ID: got %d, want %d", v.link.VLANID, test.vlanID)
+			}
+			if v.mtu != test.mtu {
+				t.Errorf("mtu: got %d, want %d", v.mtu, test.mtu)
+			}
+		})
+	}
+}
+
+func TestRenderHierarchy(t *testing.T) {
+	v := testVrouter("bond.json", []string{"10.10.0.5"}, "10.96.0.10", nil)
+	if err := v.Discover(); err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := v.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := rendered.Config[v.configName()]
+	for _, line := range []string{
+		"physical_interface=bond0.100\n",
+		"physical_interface_vlan_id=100\n",
+		"physical_interface_vlan_parent=bond0\n",
+		"physical_interface_bond_mode=802.3ad\n",
+		"physical_interface_bond_slaves=ens3f0 ens3f1\n",
+		"mtu=9000\n",
+	} {
+		if !strings.Contains(config, line) {
+			t.Errorf("config lacks %q:\n%s", line, config)
+		}
+	}
+	want := map[string]string{interfaceLabel: "bond0.100", vlanIDLabel: "100", bondModeLabel: "802.3ad", mtuLabel: "9000"}
+	for key, value := range want {
+		if rendered.PodLabels[key] != value {
+			t.Errorf("pod label %s: got %q, want %q", key, rendered.PodLabels[key], value)
+		}
+	}
+}